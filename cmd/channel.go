@@ -4,9 +4,8 @@ import (
 	"context"
 	"fmt"
 	"hse-dss-efimov/ctx"
-	"hse-dss-efimov/network"
-	"hse-dss-efimov/websocket"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"log"
 	"math/rand"
@@ -15,6 +14,7 @@ import (
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 )
 
 type ports struct {
@@ -26,43 +26,83 @@ var channelCmd = &cobra.Command{
 	Use:   "channel SRCPORT DSTPORT [WEBPORT]",
 	Short: "Establishes a channel between source port and destination port",
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) < 2 {
-			fmt.Println("Command requires at least SRCPORT and DSTPORT arguments")
+		ports_pairs, webport := parseChannelArgs(args)
+		mainChannel(ports_pairs, webport)
+	},
+}
+
+// Parses SRCPORT DSTPORT pairs optionally followed by WEBPORT; exits on malformed arguments.
+// Returns negative WEBPORT if none is given.
+func parseChannelArgs(args []string) ([]ports, int) {
+	if len(args) < 2 {
+		fmt.Println("Command requires at least SRCPORT and DSTPORT arguments")
+		os.Exit(-1)
+	}
+
+	var ports_pairs []ports
+
+	for i := 0; i < len(args) - 1; i += 2 {
+		srcport, err := strconv.Atoi(args[i])
+		if err != nil {
+			fmt.Printf("Cannot parse SRCPORT: %v", err)
 			os.Exit(-1)
 		}
 
-		var ports_pairs []ports
-
-		for i := 0; i < len(args) - 1; i += 2 {
-			srcport, err := strconv.Atoi(args[i])
-			if err != nil {
-				fmt.Printf("Cannot parse SRCPORT: %v", err)
-				os.Exit(-1)
-			}
-
-			dstport, err := strconv.Atoi(args[i + 1])
-			if err != nil {
-				fmt.Printf("Cannot parse DSTPORT: %v", err)
-				os.Exit(-1)
-			}
-			ports_pairs = append(ports_pairs, ports{srcport, dstport})
+		dstport, err := strconv.Atoi(args[i + 1])
+		if err != nil {
+			fmt.Printf("Cannot parse DSTPORT: %v", err)
+			os.Exit(-1)
 		}
+		ports_pairs = append(ports_pairs, ports{srcport, dstport})
+	}
 
-		webport, err := -1, error(nil)
-		if len(args) % 2 == 1 {
-			webport, err = strconv.Atoi(args[len(args) - 1])
-			if err != nil {
-				fmt.Printf("Cannot parse WEBPORT: %v", err)
-				os.Exit(-1)
-			}
+	webport, err := -1, error(nil)
+	if len(args) % 2 == 1 {
+		webport, err = strconv.Atoi(args[len(args) - 1])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
 		}
-
-		mainChannel(ports_pairs, webport)
-	},
+	}
+	return ports_pairs, webport
 }
 
 func init() {
 	RootCmd.AddCommand(channelCmd)
+
+	flags := channelCmd.Flags()
+	flags.Float64("drop", 0, "probability of dropping a message")
+	flags.Float64("duplicate", 0, "probability of duplicating a message")
+	flags.String("delay", "", "delay distribution: fixed:D, uniform:MIN:MAX, exponential:MEAN or pareto:SCALE:SHAPE")
+	flags.String("reorder", "", "release accepted messages reordered: random, lifo or explicit")
+	flags.Int("reorder-window", 2, "number of held messages triggering release")
+	flags.Duration("reorder-timeout", time.Second, "release held messages after this long")
+	flags.Int64("bandwidth", 0, "bandwidth limit in bytes per second (default: unlimited)")
+	flags.Int64("burst", 0, "burst size in bytes (default: one second worth of bandwidth)")
+	flags.Duration("frame-latency", 0, "propagation delay of every frame, counted since it is accepted")
+	flags.Duration("byte-latency", 0, "propagation delay added per byte of a frame")
+	flags.String("partition-policy", "reject", "fate of messages crossing a partition boundary: reject or hold")
+	flags.Int("pending-capacity", 1000, "number of messages awaiting decision per channel, 0 for unbounded")
+	flags.String("pending-policy", "block", "handling of messages beyond pending capacity: block, drop-oldest, drop-newest "+
+		"or auto-accept (delivers them without manual decision)")
+	flags.Int64("seed", 0, "random seed (default: current time)")
+	flags.String("journal", "", "path of the journal file recording messages, decisions and connections")
+
+	viper.BindPFlag("fault.drop", flags.Lookup("drop"))
+	viper.BindPFlag("fault.duplicate", flags.Lookup("duplicate"))
+	viper.BindPFlag("fault.delay", flags.Lookup("delay"))
+	viper.BindPFlag("reorder.mode", flags.Lookup("reorder"))
+	viper.BindPFlag("reorder.window", flags.Lookup("reorder-window"))
+	viper.BindPFlag("reorder.timeout", flags.Lookup("reorder-timeout"))
+	viper.BindPFlag("shaping.bandwidth", flags.Lookup("bandwidth"))
+	viper.BindPFlag("shaping.burst", flags.Lookup("burst"))
+	viper.BindPFlag("shaping.frame-latency", flags.Lookup("frame-latency"))
+	viper.BindPFlag("shaping.byte-latency", flags.Lookup("byte-latency"))
+	viper.BindPFlag("partition.policy", flags.Lookup("partition-policy"))
+	viper.BindPFlag("pending.capacity", flags.Lookup("pending-capacity"))
+	viper.BindPFlag("pending.policy", flags.Lookup("pending-policy"))
+	viper.BindPFlag("seed", flags.Lookup("seed"))
+	viper.BindPFlag("journal", flags.Lookup("journal"))
 }
 
 func httpRootHandler(w http.ResponseWriter, r *http.Request) {
@@ -94,42 +134,11 @@ func (h httpWrapperHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func mainChannel(port_pairs []ports, webport int) {
 	logger, _ := zap.NewDevelopment()
 
-	dispatcher := websocket.NewDispatcher(*logger, func(ctx websocket.CallCtx) {
-		logger.Debug("handling call", zap.Any("data", ctx.Data()))
-	})
-
-	msg_db_chan := &websocket.Chans_ports{MsgsDb:make(websocket.MsgDb), MsgChan:make(chan network.Message, 100)}
-
-	counter := uint64(0)
-	for _, pair := range port_pairs {
-		channel := network.NewChannel("c", pair.src, pair.dst, &counter, *logger, msg_db_chan.MsgChan)
-		defer channel.Close()
-	}
-
-
-	if webport > 0 {
-		m := http.NewServeMux()
-		// m.HandleFunc("/", httpRootHandler)
-		m.Handle("/web/", http.StripPrefix("/web/", http.FileServer(http.Dir("./web"))))
-		m.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("./images"))))
-		m.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			websocket.HttpHandler(dispatcher, w, r, msg_db_chan)
-		})
-		go func() {
-			logger.Debug("http server is serving on port " + strconv.Itoa(webport))
-			a := ":" + strconv.Itoa(webport)
-			h := httpWrapperHandler{*logger, m}
-			if err := http.ListenAndServe(a, h); err != nil {
-				logger.Panic("failed to listen and serve http", zap.Error(err))
-			}
-		}()
-	} else {
-		logger.Debug("http server is not serving")
-	}
+	tb := newTestbed(port_pairs, configuredSeed(logger), viper.GetString("journal"), logger)
+	defer tb.close()
+	tb.serveWeb(webport)
 
 	q := make(chan os.Signal)
 	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
 	log.Println(<-q)
-
-	dispatcher.Close()
 }
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"hse-dss-efimov/network"
+)
+
+// Returns viper key holding the setting for the given channel: per-channel
+// section "channels.NAME.KEY" overrides top-level "KEY" when present.
+func channelKey(name string, key string) string {
+	if k := "channels." + name + "." + key; viper.IsSet(k) {
+		return k
+	}
+	return key
+}
+
+func channelName(pair ports) string {
+	return fmt.Sprintf("%d-%d", pair.src, pair.dst)
+}
+
+// Builds fault model configured for the given channel; returns nil if no faults are configured.
+func faultModelFor(name string, seed int64) (*network.FaultModel, error) {
+	drop := viper.GetFloat64(channelKey(name, "fault.drop"))
+	duplicate := viper.GetFloat64(channelKey(name, "fault.duplicate"))
+	delay, err := network.ParseDelay(viper.GetString(channelKey(name, "fault.delay")))
+	if err != nil {
+		return nil, err
+	}
+	if drop < 0 || drop > 1 || duplicate < 0 || duplicate > 1 {
+		return nil, fmt.Errorf("fault probabilities must lie within [0, 1]")
+	}
+	if drop == 0 && duplicate == 0 && delay == nil {
+		return nil, nil
+	}
+	return network.NewFaultModel(drop, duplicate, delay, seed), nil
+}
+
+// Builds hold-back buffer configuration for the given channel.
+func reorderFor(name string, seed int64) (network.Reorder, error) {
+	mode, err := network.ParseReorderMode(viper.GetString(channelKey(name, "reorder.mode")))
+	if err != nil {
+		return network.Reorder{}, err
+	}
+	return network.Reorder{
+		Mode:    mode,
+		Window:  viper.GetInt(channelKey(name, "reorder.window")),
+		Timeout: viper.GetDuration(channelKey(name, "reorder.timeout")),
+		Seed:    seed,
+	}, nil
+}
+
+// Returns bound of the pending queue of the given channel and the policy applied on overflow.
+func pendingLimitFor(name string) (int, network.OverflowPolicy, error) {
+	capacity := viper.GetInt(channelKey(name, "pending.capacity"))
+	if capacity < 0 {
+		return 0, network.OverflowBlock, fmt.Errorf("pending queue capacity must not be negative")
+	}
+	policy, err := network.ParseOverflowPolicy(viper.GetString(channelKey(name, "pending.policy")))
+	return capacity, policy, err
+}
+
+// Builds traffic shaping configuration for the given channel.
+func shapingFor(name string) (network.Shaping, error) {
+	config := network.Shaping{
+		Bandwidth:    viper.GetInt64(channelKey(name, "shaping.bandwidth")),
+		Burst:        viper.GetInt64(channelKey(name, "shaping.burst")),
+		FrameLatency: viper.GetDuration(channelKey(name, "shaping.frame-latency")),
+		ByteLatency:  viper.GetDuration(channelKey(name, "shaping.byte-latency")),
+	}
+	if config.Bandwidth < 0 || config.Burst < 0 || config.FrameLatency < 0 || config.ByteLatency < 0 {
+		return network.Shaping{}, fmt.Errorf("shaping parameters must not be negative")
+	}
+	return config, nil
+}
+
+// Parses channel name of the form SRCPORT-DSTPORT.
+func parseChannelName(name string) (ports, error) {
+	var pair ports
+	if _, err := fmt.Sscanf(name, "%d-%d", &pair.src, &pair.dst); err != nil {
+		return ports{}, fmt.Errorf("malformed channel name %q: %v", name, err)
+	}
+	return pair, nil
+}
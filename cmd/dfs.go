@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"hse-dss-efimov/explore"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var exploreDFSCmd = &cobra.Command{
+	Use:   "dfs SRCPORT DSTPORT [SRCPORT DSTPORT...]",
+	Short: "Enumerates distinct delivery orders of messages, restarting the system for every one",
+	Long: "Explores delivery orders of pending messages depth first. For every execution channels are established " +
+		"anew and the system command is started; once no message is pending for the quiescence period, the check " +
+		"command is run and its non-zero exit status is reported as violation. Deliveries to different destination " +
+		"ports are considered to commute, so that executions differing only in their order are explored once.",
+	Run: func(cmd *cobra.Command, args []string) {
+		port_pairs, webport := parseChannelArgs(args)
+		if webport > 0 {
+			fmt.Println("Command does not serve web interface, WEBPORT must not be given")
+			os.Exit(-1)
+		}
+		os.Exit(mainDFS(cmd, port_pairs))
+	},
+}
+
+func init() {
+	exploreCmd.AddCommand(exploreDFSCmd)
+
+	flags := exploreDFSCmd.Flags()
+	addSystemFlags(flags)
+	flags.Int("max-executions", 0, "stop after this many distinct executions (default: no limit)")
+}
+
+// Explores executions of the system; returns exit status, non-zero if any of them violates the check.
+func mainDFS(cmd *cobra.Command, port_pairs []ports) int {
+	logger, _ := zap.NewDevelopment()
+
+	flags := cmd.Flags()
+	step, _ := flags.GetDuration("step")
+	quiescence, _ := flags.GetDuration("quiescence")
+	maxSteps, _ := flags.GetInt("max-steps")
+	maxExecutions, _ := flags.GetInt("max-executions")
+
+	seed := configuredSeed(logger)
+	system, err := newProcessSystem(cmd, logger, port_pairs, seed, viper.GetString("journal"))
+	if err != nil {
+		fmt.Println(err)
+		return -1
+	}
+	fmt.Printf("Exploring with seed %d\n", seed)
+	dfs := explore.NewDFS(system, step, quiescence, maxSteps, maxExecutions, *logger)
+
+	stopCh := make(chan struct{})
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		logger.Info("stopping exploration", zap.Stringer("signal", <-q))
+		close(stopCh)
+	}()
+
+	result, err := dfs.Run(stopCh)
+	if err != nil {
+		fmt.Println(err)
+		return -1
+	}
+
+	state := "interrupted"
+	if result.Complete {
+		state = "complete"
+	} else if maxExecutions > 0 && result.Executions == maxExecutions {
+		state = "reached execution limit"
+	}
+	fmt.Printf("Exploration %v: %d distinct executions, %d pruned as equivalent, %d not reproducible, %d violations\n",
+		state, result.Executions, result.Pruned, result.Nondeterministic, len(result.Violations))
+	for _, violation := range result.Violations {
+		fmt.Printf("Execution %d violated check: %v\n", violation.Number, violation.Err)
+		fmt.Printf("  schedule: %v\n", violation.Schedule)
+		if path := system.journalFor(violation.Number); path != "" {
+			fmt.Printf("  journal: %v\n", path)
+		}
+	}
+	if len(result.Violations) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"hse-dss-efimov/explore"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var exploreCmd = &cobra.Command{
+	Use:   "explore",
+	Short: "Establishes channels and delivers their messages in an order chosen by a scheduler",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Bound here rather than in init, so that they do not take over the keys of channel command.
+		flags := cmd.Flags()
+		viper.BindPFlag("seed", flags.Lookup("seed"))
+		viper.BindPFlag("journal", flags.Lookup("journal"))
+	},
+}
+
+var exploreRandomCmd = &cobra.Command{
+	Use:   "random SRCPORT DSTPORT [SRCPORT DSTPORT...] [WEBPORT]",
+	Short: "Repeatedly accepts a message picked at random among pending ones",
+	Run: func(cmd *cobra.Command, args []string) {
+		port_pairs, webport := parseChannelArgs(args)
+		os.Exit(mainExplore(cmd, port_pairs, webport, func(seed int64) explore.Strategy {
+			return explore.NewRandom(seed)
+		}))
+	},
+}
+
+var explorePCTCmd = &cobra.Command{
+	Use:   "pct SRCPORT DSTPORT [SRCPORT DSTPORT...] [WEBPORT]",
+	Short: "Accepts pending messages by random priorities of nodes or channels, changing them at random steps",
+	Long: "Probabilistic concurrency testing: every node or channel gets a random priority and the oldest pending " +
+		"message of the one with the highest priority is accepted. At depth-1 steps chosen at random among the " +
+		"expected number of steps the one about to be accepted drops below all others, exposing ordering bugs " +
+		"which take that many ordering constraints to manifest.",
+	Run: func(cmd *cobra.Command, args []string) {
+		port_pairs, webport := parseChannelArgs(args)
+
+		flags := cmd.Flags()
+		depth, _ := flags.GetInt("depth")
+		steps, _ := flags.GetInt("expected-steps")
+		unitName, _ := flags.GetString("unit")
+		unit, err := explore.ParseUnit(unitName)
+		if err != nil {
+			fmt.Printf("Cannot parse unit: %v\n", err)
+			os.Exit(-1)
+		}
+		if depth < 1 {
+			fmt.Println("Depth must be positive")
+			os.Exit(-1)
+		}
+		os.Exit(mainExplore(cmd, port_pairs, webport, func(seed int64) explore.Strategy {
+			return explore.NewPCT(seed, depth, steps, unit)
+		}))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exploreCmd)
+	exploreCmd.AddCommand(exploreRandomCmd)
+	exploreCmd.AddCommand(explorePCTCmd)
+
+	pctFlags := explorePCTCmd.Flags()
+	pctFlags.Int("depth", 3, "bug depth: number of ordering constraints to hit, one more than the number of priority changes")
+	pctFlags.Int("expected-steps", 100, "expected number of accepted messages, over which priority changes are spread")
+	pctFlags.String("unit", "node", "entity priorities are assigned to: node or channel")
+
+	flags := exploreCmd.PersistentFlags()
+	flags.Int64("seed", 0, "random seed (default: current time)")
+	flags.String("journal", "", "path of the journal file recording messages, decisions and connections")
+	flags.Duration("step", 100*time.Millisecond, "pause after every accepted message, letting nodes react")
+	flags.Duration("quiescence", 2*time.Second, "stop once no message is pending for this long")
+	flags.Int("max-steps", 0, "stop after accepting this many messages (default: no limit)")
+}
+
+// Explores channels with the strategy built from the seed; returns exit status.
+func mainExplore(cmd *cobra.Command, port_pairs []ports, webport int, newStrategy func(seed int64) explore.Strategy) int {
+	logger, _ := zap.NewDevelopment()
+
+	seed := configuredSeed(logger)
+	fmt.Printf("Exploring with seed %d\n", seed)
+
+	tb := newTestbed(port_pairs, seed, viper.GetString("journal"), logger)
+	defer tb.close()
+	tb.serveWeb(webport)
+
+	flags := cmd.Flags()
+	step, _ := flags.GetDuration("step")
+	quiescence, _ := flags.GetDuration("quiescence")
+	maxSteps, _ := flags.GetInt("max-steps")
+	strategy := newStrategy(seed)
+	fmt.Printf("Using %v\n", strategy)
+	explorer := explore.NewExplorer(tb.channels(), strategy, step, quiescence, maxSteps, *logger)
+
+	stopCh := make(chan struct{})
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		logger.Info("stopping exploration", zap.Stringer("signal", <-q))
+		close(stopCh)
+	}()
+
+	result := explorer.Run(stopCh)
+	switch {
+	case result.Quiescent:
+		fmt.Printf("Exploration quiesced after %d steps using %v with seed %d\n", result.Steps, strategy, seed)
+	case maxSteps > 0 && result.Steps == maxSteps:
+		fmt.Printf("Exploration reached %d steps using %v with seed %d\n", result.Steps, strategy, seed)
+	default:
+		fmt.Printf("Exploration interrupted after %d steps using %v with seed %d\n", result.Steps, strategy, seed)
+		return 1
+	}
+	return 0
+}
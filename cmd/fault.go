@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	hsews "hse-dss-efimov/websocket"
+	"os"
+	"strconv"
+)
+
+var faultCmd = &cobra.Command{
+	Use:   "fault WEBPORT CHANNEL KIND",
+	Short: "Triggers connection-level fault in a channel of a running instance",
+	Long: "Triggers fault KIND in the channel named SRCPORT-DSTPORT of an instance serving web interface on WEBPORT. " +
+		"KIND is one of: reset, halfclose-inbound, halfclose-outbound, refuse, drop-frame.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			fmt.Println("Command requires WEBPORT, CHANNEL and KIND arguments")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		duration, _ := cmd.Flags().GetDuration("duration")
+		request := hsews.Message{Kind: hsews.MK_Request, Request: "fault", Channel: args[1], Data: args[2],
+			Delay: duration.Nanoseconds() / 1e6}
+		if _, err := requestRemote(webport, request); err != nil {
+			fmt.Printf("Cannot trigger fault: %v\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(faultCmd)
+
+	faultCmd.Flags().Duration("duration", 0, "period of refusing connections")
+}
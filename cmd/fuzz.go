@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"hse-dss-efimov/explore"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"syscall"
+)
+
+var exploreFuzzCmd = &cobra.Command{
+	Use:   "fuzz SRCPORT DSTPORT [SRCPORT DSTPORT...]",
+	Short: "Mutates schedules from a corpus, keeping the ones reaching new states or message sequences",
+	Long: "Greybox fuzzing of schedules. Every iteration restarts the system and decides on its messages by a " +
+		"mutant of a schedule from the corpus: two deliveries swapped, a message dropped or delayed. Mutants " +
+		"reaching new states printed by the state command or new pairs of consecutive message types delivered " +
+		"to a node join the corpus. Schedules and journals of iterations violating the check are saved in the " +
+		"corpus directory; journals can be replayed and shrunk.",
+	Run: func(cmd *cobra.Command, args []string) {
+		port_pairs, webport := parseChannelArgs(args)
+		if webport > 0 {
+			fmt.Println("Command does not serve web interface, WEBPORT must not be given")
+			os.Exit(-1)
+		}
+		os.Exit(mainFuzz(cmd, port_pairs))
+	},
+}
+
+func init() {
+	exploreCmd.AddCommand(exploreFuzzCmd)
+
+	flags := exploreFuzzCmd.Flags()
+	addSystemFlags(flags)
+	flags.String("state", "", "shell command printing state of the nodes, run after every decision")
+	flags.Int("iterations", 100, "number of iterations, 0 to run until interrupted")
+	flags.String("corpus", "corpus", "directory keeping the corpus, violating schedules and their journals")
+	flags.String("message-type", `^[A-Za-z_][A-Za-z0-9_-]*`,
+		"regular expression matching type of a message in its payload; its first group is used if it has any")
+}
+
+// Schedule saved in the corpus directory.
+type savedSchedule struct {
+	Iteration int                `json:"iteration"`
+	Seed      int64              `json:"seed"`
+	Violation string             `json:"violation,omitempty"`
+	Journal   string             `json:"journal,omitempty"`
+	Schedule  []explore.Decision `json:"schedule"`
+}
+
+func saveSchedule(path string, saved savedSchedule) error {
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Loads schedules saved in the corpus by earlier runs.
+func loadCorpus(dir string) ([][]explore.Decision, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var corpus [][]explore.Decision
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var saved savedSchedule
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		corpus = append(corpus, saved.Schedule)
+	}
+	return corpus, nil
+}
+
+// Returns function extracting type of a message from its payload by the regular expression.
+func messageTypeOf(expr string) (func(payload []byte) string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload []byte) string {
+		match := re.FindSubmatch(payload)
+		switch {
+		case match == nil:
+			return ""
+		case len(match) > 1:
+			return string(match[1])
+		default:
+			return string(match[0])
+		}
+	}, nil
+}
+
+// Fuzzes schedules of the system; returns exit status, non-zero if any iteration violates the check.
+func mainFuzz(cmd *cobra.Command, port_pairs []ports) int {
+	logger, _ := zap.NewDevelopment()
+
+	flags := cmd.Flags()
+	dir, _ := flags.GetString("corpus")
+	iterations, _ := flags.GetInt("iterations")
+	state, _ := flags.GetString("state")
+	expr, _ := flags.GetString("message-type")
+	step, _ := flags.GetDuration("step")
+	quiescence, _ := flags.GetDuration("quiescence")
+	maxSteps, _ := flags.GetInt("max-steps")
+
+	typeOf, err := messageTypeOf(expr)
+	if err != nil {
+		fmt.Printf("Cannot parse message type expression: %v\n", err)
+		return -1
+	}
+	corpusDir := filepath.Join(dir, "schedules")
+	if err := os.MkdirAll(corpusDir, 0755); err != nil {
+		fmt.Printf("Cannot create corpus directory: %v\n", err)
+		return -1
+	}
+	corpus, err := loadCorpus(corpusDir)
+	if err != nil {
+		fmt.Printf("Cannot load corpus: %v\n", err)
+		return -1
+	}
+
+	seed := configuredSeed(logger)
+	// Journals are named after the seed like the schedules referring to them, so
+	// that another run keeps them.
+	journals := filepath.Join(dir, fmt.Sprintf("journal-%d", seed))
+	processSystem, err := newProcessSystem(cmd, logger, port_pairs, seed, journals)
+	if err != nil {
+		fmt.Println(err)
+		return -1
+	}
+	processSystem.state = state
+	var system explore.System = processSystem
+	if state == "" {
+		// Hides State of processSystem, so that the fuzzer does not ask for it.
+		system = struct{ explore.System }{processSystem}
+	}
+	fmt.Printf("Fuzzing with seed %d\n", seed)
+
+	fuzzer := explore.NewFuzzer(system, seed, step, quiescence, maxSteps, typeOf, *logger)
+	for _, schedule := range corpus {
+		fuzzer.AddCorpus(schedule)
+	}
+	logger.Info("loaded corpus", zap.Int("schedules", len(corpus)))
+
+	var stopped int32
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		logger.Info("stopping fuzzing", zap.Stringer("signal", <-q))
+		atomic.StoreInt32(&stopped, 1)
+	}()
+
+	violations := 0
+	done := 0
+	for ; (iterations == 0 || done < iterations) && atomic.LoadInt32(&stopped) == 0; done++ {
+		iteration, err := fuzzer.Iterate()
+		if err != nil {
+			fmt.Println(err)
+			return -1
+		}
+		logger.Info("iteration finished",
+			zap.Int("iteration", iteration.Number),
+			zap.Int("decisions", len(iteration.Schedule)),
+			zap.Int("newCoverage", iteration.NewCoverage),
+			zap.Int("coverage", fuzzer.Coverage()),
+			zap.Int("corpus", fuzzer.CorpusSize()),
+			zap.Bool("violation", iteration.Err != nil))
+
+		saved := savedSchedule{Iteration: iteration.Number, Seed: seed, Schedule: iteration.Schedule}
+		journalPath := processSystem.journalFor(iteration.Number)
+		if iteration.NewCoverage > 0 {
+			path := filepath.Join(corpusDir, fmt.Sprintf("%06d-%d.json", iteration.Number, seed))
+			if err := saveSchedule(path, saved); err != nil {
+				logger.Error("cannot save schedule", zap.Error(err))
+			}
+		}
+		if iteration.Err == nil {
+			os.Remove(journalPath)
+			continue
+		}
+		violations++
+		saved.Violation = iteration.Err.Error()
+		saved.Journal = journalPath
+		path := filepath.Join(dir, fmt.Sprintf("violation-%06d-%d.json", iteration.Number, seed))
+		if err := saveSchedule(path, saved); err != nil {
+			logger.Error("cannot save violating schedule", zap.Error(err))
+		}
+		fmt.Printf("Iteration %d violated check: %v\n", iteration.Number, iteration.Err)
+		fmt.Printf("  schedule: %v\n  journal: %v\n", path, journalPath)
+	}
+	fmt.Printf("Fuzzing finished after %d iterations: %d coverage features, %d schedules in corpus, %d violations\n",
+		done, fuzzer.Coverage(), fuzzer.CorpusSize(), violations)
+	if violations > 0 {
+		return 1
+	}
+	return 0
+}
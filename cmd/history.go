@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"hse-dss-efimov/store"
+	hsews "hse-dss-efimov/websocket"
+	"os"
+	"strconv"
+	"time"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history WEBPORT",
+	Short: "Prints messages recorded by a running instance, one JSON record per line",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("Command requires WEBPORT argument")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		flags := cmd.Flags()
+		query := &store.Query{}
+		query.Channel, _ = flags.GetString("channel")
+		query.Src, _ = flags.GetString("src")
+		query.Dst, _ = flags.GetString("dst")
+		statuses, _ := flags.GetStringSlice("status")
+		for _, name := range statuses {
+			status, err := store.ParseStatus(name)
+			if err != nil {
+				fmt.Printf("Cannot parse status: %v", err)
+				os.Exit(-1)
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+		if since, _ := flags.GetDuration("since"); since > 0 {
+			query.From = time.Now().Add(-since)
+		}
+
+		reply, err := requestRemote(webport, hsews.Message{Kind: hsews.MK_Request, Request: "history", Query: query})
+		if err != nil {
+			fmt.Printf("Cannot query history: %v\n", err)
+			os.Exit(-1)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, record := range reply.Records {
+			enc.Encode(record)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	flags := historyCmd.Flags()
+	flags.String("channel", "", "only messages of the channel")
+	flags.String("src", "", "only messages from the source port")
+	flags.String("dst", "", "only messages to the destination port")
+	flags.StringSlice("status", nil, "only messages in the statuses: pending, accepted, rejected, sent")
+	flags.Duration("since", 0, "only messages received within this period")
+}
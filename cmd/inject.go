@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	hsews "hse-dss-efimov/websocket"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+var injectCmd = &cobra.Command{
+	Use:   "inject WEBPORT CHANNEL [PAYLOAD]",
+	Short: "Injects a forged message into a channel of a running instance",
+	Long: "Injects a forged message into the channel named SRCPORT-DSTPORT of an instance serving web interface " +
+		"on WEBPORT. Payload is read from standard input unless given as an argument.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("Command requires at least WEBPORT and CHANNEL arguments")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		var payload []byte
+		if len(args) > 2 {
+			payload = []byte(args[2])
+		} else if payload, err = ioutil.ReadAll(os.Stdin); err != nil {
+			fmt.Printf("Cannot read payload: %v", err)
+			os.Exit(-1)
+		}
+
+		seqnum, err := injectRemote(webport, args[1], payload)
+		if err != nil {
+			fmt.Printf("Cannot inject message: %v\n", err)
+			os.Exit(-1)
+		}
+		fmt.Println(seqnum)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(injectCmd)
+}
+
+// Asks the instance serving websocket on webport to inject Message; returns its sequence number.
+func injectRemote(webport int, channel string, payload []byte) (string, error) {
+	request := hsews.Message{Kind: hsews.MK_Request, Request: "inject", Channel: channel, Payload: string(payload)}
+	reply, err := requestRemote(webport, request)
+	if err != nil {
+		return "", err
+	}
+	return reply.MsgNumber, nil
+}
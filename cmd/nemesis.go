@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	hsews "hse-dss-efimov/websocket"
+	"os"
+	"strconv"
+	"time"
+)
+
+var nemesisCmd = &cobra.Command{
+	Use:   "nemesis WEBPORT FAULT NODE",
+	Short: "Applies fault to a node supervised by a running instance",
+	Long: "Applies FAULT to NODE of the topology run by an instance serving web interface on WEBPORT. " +
+		"FAULT is one of: crash, stop, restart, pause, resume. Paused node is frozen with SIGSTOP while its " +
+		"channels keep accepting traffic from peers, emulating a long garbage collection pause.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			fmt.Println("Command requires WEBPORT, FAULT and NODE arguments")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		duration, _ := cmd.Flags().GetDuration("duration")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		request := hsews.Message{Kind: hsews.MK_Request, Request: "nemesis", Data: args[1], Name: args[2],
+			Delay: duration.Nanoseconds() / 1e6}
+		if _, err := requestRemoteWithin(webport, request, timeout); err != nil {
+			fmt.Printf("Cannot apply fault: %v\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(nemesisCmd)
+
+	nemesisCmd.Flags().Duration("duration", 0, "restart crashed or stopped node, or resume paused one, after this long (default: leave it so)")
+	nemesisCmd.Flags().Duration("timeout", 30*time.Second, "time to wait for the fault to be applied; must exceed stop timeout of the instance")
+}
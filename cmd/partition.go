@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	hsews "hse-dss-efimov/websocket"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var partitionCmd = &cobra.Command{
+	Use:   "partition WEBPORT NAME GROUP GROUP [GROUP...]",
+	Short: "Partitions network of a running instance",
+	Long: "Creates partition NAME separating groups of ports in an instance serving web interface on WEBPORT. " +
+		"Every GROUP is a comma-separated list of ports of one or more nodes.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 4 {
+			fmt.Println("Command requires WEBPORT, NAME and at least two GROUP arguments")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		groups := make([][]int, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			group, err := parsePorts(arg)
+			if err != nil {
+				fmt.Printf("Cannot parse GROUP: %v", err)
+				os.Exit(-1)
+			}
+			groups = append(groups, group)
+		}
+
+		oneWay, _ := cmd.Flags().GetBool("one-way")
+		request := hsews.Message{Kind: hsews.MK_Request, Request: "partition", Name: args[1], Groups: groups, OneWay: oneWay}
+		if _, err := requestRemote(webport, request); err != nil {
+			fmt.Printf("Cannot partition network: %v\n", err)
+			os.Exit(-1)
+		}
+	},
+}
+
+var healCmd = &cobra.Command{
+	Use:   "heal WEBPORT [NAME]",
+	Short: "Heals network partition of a running instance",
+	Long:  "Heals partition NAME, or all partitions if NAME is omitted, in an instance serving web interface on WEBPORT.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("Command requires WEBPORT argument")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		request := hsews.Message{Kind: hsews.MK_Request, Request: "heal"}
+		if len(args) > 1 {
+			request.Name = args[1]
+		}
+		request.Release, _ = cmd.Flags().GetBool("release")
+		reply, err := requestRemote(webport, request)
+		if err != nil {
+			fmt.Printf("Cannot heal network partition: %v\n", err)
+			os.Exit(-1)
+		}
+		fmt.Printf("%s held messages processed\n", reply.MsgNumber)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(partitionCmd)
+	RootCmd.AddCommand(healCmd)
+
+	partitionCmd.Flags().Bool("one-way", false, "cut traffic only from every group towards the groups following it")
+	healCmd.Flags().Bool("release", false, "deliver messages held by the partition instead of dropping them")
+}
+
+// Parses comma-separated list of ports.
+func parsePorts(s string) ([]int, error) {
+	fields := strings.Split(s, ",")
+	ports := make([]int, 0, len(fields))
+	for _, field := range fields {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
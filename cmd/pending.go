@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	hsews "hse-dss-efimov/websocket"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var pendingCmd = &cobra.Command{
+	Use:   "pending WEBPORT",
+	Short: "Prints number of messages awaiting decision in every channel of a running instance",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("Command requires WEBPORT argument")
+			os.Exit(-1)
+		}
+
+		webport, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Printf("Cannot parse WEBPORT: %v", err)
+			os.Exit(-1)
+		}
+
+		reply, err := requestRemote(webport, hsews.Message{Kind: hsews.MK_Request, Request: "pending"})
+		if err != nil {
+			fmt.Printf("Cannot query pending messages: %v\n", err)
+			os.Exit(-1)
+		}
+		names := make([]string, 0, len(reply.Depths))
+		for name := range reply.Depths {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%v\t%d\n", name, reply.Depths[name])
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pendingCmd)
+}
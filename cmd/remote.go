@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	hsews "hse-dss-efimov/websocket"
+	"strconv"
+	"time"
+)
+
+const remoteTimeout = 5 * time.Second
+
+// Sends request to the instance serving websocket on webport and waits for the reply.
+// Reply carrying non-empty Data reports failure.
+func requestRemote(webport int, request hsews.Message) (hsews.Message, error) {
+	return requestRemoteWithin(webport, request, remoteTimeout)
+}
+
+// Same as requestRemote, waiting for the reply as long as the given timeout.
+func requestRemoteWithin(webport int, request hsews.Message, timeout time.Duration) (hsews.Message, error) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+strconv.Itoa(webport)+"/ws", nil)
+	if err != nil {
+		return hsews.Message{}, err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(remoteTimeout))
+	if err := conn.WriteJSON(request); err != nil {
+		return hsews.Message{}, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		var reply hsews.Message
+		if err := conn.ReadJSON(&reply); err != nil {
+			return hsews.Message{}, err
+		}
+		// Intercepted messages may arrive before the reply.
+		if reply.Kind != hsews.MK_Response || reply.Request != request.Request {
+			continue
+		}
+		if reply.Data != "" {
+			return reply, fmt.Errorf("%v", reply.Data)
+		}
+		return reply, nil
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"hse-dss-efimov/journal"
+	"hse-dss-efimov/replay"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay JOURNAL [WEBPORT]",
+	Short: "Replays decisions recorded in a journal",
+	Long: "Establishes the channels recorded in JOURNAL and decides on their messages the way they were " +
+		"decided on in the recorded run. Live messages are matched to recorded ones by channel, arrival index " +
+		"and Crc64; the first divergence is reported and makes the command exit with non-zero status.\n\n" +
+		"Given WEBPORT, channels are switched back to manual deciding through the web interface once replay " +
+		"completes or reaches the stop point, so that the execution can be branched from there.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("Command requires JOURNAL argument")
+			os.Exit(-1)
+		}
+
+		webport := -1
+		if len(args) > 1 {
+			var err error
+			if webport, err = strconv.Atoi(args[1]); err != nil {
+				fmt.Printf("Cannot parse WEBPORT: %v", err)
+				os.Exit(-1)
+			}
+		}
+
+		flags := cmd.Flags()
+		stopStep, _ := flags.GetInt("stop-step")
+		stopSeqnum, _ := flags.GetUint64("stop-seqnum")
+		if (stopStep >= 0 || stopSeqnum > 0) && webport <= 0 {
+			fmt.Println("Stop point requires WEBPORT argument")
+			os.Exit(-1)
+		}
+		if stopStep >= 0 && stopSeqnum > 0 {
+			fmt.Println("Either --stop-step or --stop-seqnum may be given, not both")
+			os.Exit(-1)
+		}
+
+		header, records, err := journal.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("Cannot read journal: %v\n", err)
+			os.Exit(-1)
+		}
+
+		port_pairs, err := journalPorts(header)
+		if err != nil {
+			fmt.Printf("Cannot parse journal header: %v\n", err)
+			os.Exit(-1)
+		}
+
+		idle, _ := flags.GetDuration("idle-timeout")
+		linger, _ := flags.GetDuration("linger")
+		lenient, _ := flags.GetBool("lenient")
+		pace, _ := flags.GetDuration("pace")
+		os.Exit(mainReplay(header.Seed, port_pairs, records, stopStep, stopSeqnum, lenient, webport, idle, pace, linger))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayCmd)
+
+	flags := replayCmd.Flags()
+	flags.Duration("idle-timeout", 10*time.Second, "report divergence if no recorded message arrives for this long")
+	flags.Duration("linger", time.Second, "keep channels open this long after replay, so that replayed messages get delivered")
+	flags.Int("stop-step", -1, "replay only this many leading decisions, then switch to manual deciding")
+	flags.Uint64("stop-seqnum", 0, "replay decisions up to the one on the message with this recorded sequence number, then switch to manual deciding")
+	flags.Duration("pace", 100*time.Millisecond, "minimum interval between decisions, letting decided messages reach their destinations")
+	flags.Bool("lenient", false, "accept messages no decision is recorded for and skip decisions on messages which do not arrive, as for shrunk journals")
+}
+
+// Returns port pairs of the channels recorded in journal header.
+func journalPorts(header journal.Record) ([]ports, error) {
+	var port_pairs []ports
+	for _, name := range header.Channels {
+		pair, err := parseChannelName(name)
+		if err != nil {
+			return nil, err
+		}
+		port_pairs = append(port_pairs, pair)
+	}
+	if len(port_pairs) == 0 {
+		return nil, fmt.Errorf("journal records no channels")
+	}
+	return port_pairs, nil
+}
+
+// Returns exit status: zero if the replay completes, one if it diverges or gets interrupted.
+// Every recorded decision is replayed unless stopStep is non-negative or stopSeqnum is set.
+func mainReplay(seed int64, port_pairs []ports, records []journal.Record, stopStep int, stopSeqnum uint64,
+	lenient bool, webport int, idle time.Duration, pace time.Duration, linger time.Duration) int {
+	logger, _ := zap.NewDevelopment()
+
+	tb := newTestbed(port_pairs, seed, "", logger)
+	defer tb.close()
+	tb.serveWeb(webport)
+
+	replayer := replay.NewReplayer(records, tb.channels(), *logger)
+	if stopSeqnum > 0 {
+		var ok bool
+		if stopStep, ok = replayer.StepOf(stopSeqnum); !ok {
+			fmt.Printf("Journal records no decision on message %d\n", stopSeqnum)
+			return -1
+		}
+	}
+	if stopStep >= 0 {
+		if err := replayer.StopAfter(stopStep); err != nil {
+			fmt.Printf("Cannot stop replay: %v\n", err)
+			return -1
+		}
+	}
+	if lenient {
+		replayer.SetLenient()
+	}
+	replayer.SetPace(pace)
+	tb.setInterceptor(replayer)
+	replayer.Start()
+	logger.Info("replaying journal", zap.Int("steps", replayer.Steps()))
+
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- replayer.Wait(idle)
+	}()
+
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	case sig := <-q:
+		fmt.Printf("Replay interrupted by %v\n", sig)
+		return 1
+	}
+	if lenient {
+		fmt.Printf("Replay complete: %d decisions, %d skipped\n", replayer.Steps(), replayer.Skipped())
+	} else {
+		fmt.Printf("Replay complete: %d decisions\n", replayer.Steps())
+	}
+
+	if webport > 0 {
+		logger.Info("switching to manual deciding", zap.Int("webport", webport))
+		tb.setManual()
+		logger.Info("terminating", zap.Stringer("signal", <-q))
+		return 0
+	}
+	time.Sleep(linger)
+	return 0
+}
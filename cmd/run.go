@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"hse-dss-efimov/nemesis"
+	"hse-dss-efimov/supervisor"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run TOPOLOGY [WEBPORT]",
+	Short: "Starts nodes of a topology along with channels between them",
+	Long: "Starts nodes described in the TOPOLOGY file, capturing their output into the log directory and " +
+		"restarting exited ones by their restart policies, then establishes a channel from every port a node " +
+		"connects to towards the port its peer listens on. Arguments, working directory and environment of " +
+		"a node are templates rendered with its Name, Index, Port and Peers, e.g.\n\n" +
+		"nodes:\n" +
+		"  - name: a\n" +
+		"    command: python3\n" +
+		"    args: [node.py, \"{{.Port}}\", \"{{.Peers.b}}\"]\n" +
+		"    env: [\"NODE={{.Name}}\"]\n" +
+		"    port: 7002\n" +
+		"    peers: {b: 7001}\n" +
+		"    restart: on-failure\n" +
+		"  - name: b\n" +
+		"    ...\n\n" +
+		"Faults may be scheduled in the same file, counting time since channels are established:\n\n" +
+		"nemesis:\n" +
+		"  - {after: 5s, fault: crash, node: a, duration: 3s}\n\n" +
+		"Faults are crash (SIGKILL), stop (SIGTERM), restart, pause (SIGSTOP) and resume (SIGCONT); a crashed or " +
+		"stopped node is restarted, and a paused one resumed, after duration unless it is zero. Faults may also " +
+		"be applied on demand by nemesis command.",
+	PreRun: func(cmd *cobra.Command, args []string) {
+		// Bound here rather than in init, so that they do not take over the keys of channel command.
+		flags := cmd.Flags()
+		viper.BindPFlag("seed", flags.Lookup("seed"))
+		viper.BindPFlag("journal", flags.Lookup("journal"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || len(args) > 2 {
+			fmt.Println("Command requires TOPOLOGY and optional WEBPORT arguments")
+			os.Exit(-1)
+		}
+		webport := -1
+		if len(args) == 2 {
+			var err error
+			if webport, err = strconv.Atoi(args[1]); err != nil {
+				fmt.Printf("Cannot parse WEBPORT: %v", err)
+				os.Exit(-1)
+			}
+		}
+		os.Exit(mainRun(cmd, args[0], webport))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+
+	flags := runCmd.Flags()
+	flags.String("logs", "logs", "directory for standard output and error of the nodes")
+	flags.Duration("startup", 500*time.Millisecond, "time given to the nodes to start listening before channels are established")
+	flags.Duration("stop-timeout", 5*time.Second, "time given to a node to exit on SIGTERM before it is killed")
+	flags.String("crash-policy", "drop", "fate of messages to and from a crashed or stopped node: drop, or hold until restart "+
+		"(then messages it sent are delivered, while messages to it are dropped)")
+	flags.Int64("seed", 0, "random seed (default: current time)")
+	flags.String("journal", "", "path of the journal file recording messages, decisions and connections")
+}
+
+// Topology along with faults scheduled for its nodes.
+type topologyFile struct {
+	supervisor.Topology `mapstructure:",squash"`
+	Nemesis             []nemesis.Scheduled `mapstructure:"nemesis"`
+}
+
+// Reads topology from a file in any format supported by viper.
+func readTopology(path string) (topologyFile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	var file topologyFile
+	if err := v.ReadInConfig(); err != nil {
+		return file, err
+	}
+	if err := v.Unmarshal(&file); err != nil {
+		return file, err
+	}
+	return file, file.Validate()
+}
+
+// Runs the topology until interrupted; returns exit status.
+func mainRun(cmd *cobra.Command, path string, webport int) int {
+	logger, _ := zap.NewDevelopment()
+
+	flags := cmd.Flags()
+	logDir, _ := flags.GetString("logs")
+	startup, _ := flags.GetDuration("startup")
+	stopTimeout, _ := flags.GetDuration("stop-timeout")
+	crashPolicyName, _ := flags.GetString("crash-policy")
+
+	crashPolicy, err := nemesis.ParsePendingPolicy(crashPolicyName)
+	if err != nil {
+		fmt.Printf("Cannot parse crash policy: %v\n", err)
+		return -1
+	}
+	topology, err := readTopology(path)
+	if err != nil {
+		fmt.Printf("Cannot read topology: %v\n", err)
+		return -1
+	}
+	sup, err := supervisor.NewSupervisor(topology.Topology, logDir, stopTimeout, *logger)
+	if err != nil {
+		fmt.Printf("Cannot prepare nodes: %v\n", err)
+		return -1
+	}
+	if err := sup.Start(); err != nil {
+		fmt.Println(err)
+		return -1
+	}
+	defer sup.Close()
+
+	// Nodes go first, so that channels find them listening; see processSystem.Start.
+	time.Sleep(startup)
+	var port_pairs []ports
+	for _, channel := range topology.Channels() {
+		port_pairs = append(port_pairs, ports{channel.Src, channel.Dst})
+	}
+	tb := newTestbed(port_pairs, configuredSeed(logger), viper.GetString("journal"), logger)
+	// Closed ahead of the nodes, so that channels do not reconnect to stopped ones.
+	defer tb.close()
+
+	nem := nemesis.NewNemesis(sup, topology.Ports(), tb.channels(), crashPolicy, *logger)
+	defer nem.Close()
+	if tb.journal != nil {
+		sup.SetObserver(tb.journal)
+		nem.SetObserver(tb.journal)
+		// Nodes stopping at the end are not part of the run.
+		defer sup.SetObserver(nil)
+	}
+	tb.addInterceptor(nem)
+	tb.msgDbChan.Nemesis = nem
+	tb.serveWeb(webport)
+	if err := nem.Schedule(topology.Nemesis); err != nil {
+		fmt.Printf("Cannot schedule faults: %v\n", err)
+		return -1
+	}
+
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	logger.Info("stopping", zap.Stringer("signal", <-q))
+	return 0
+}
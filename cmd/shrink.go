@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"hse-dss-efimov/journal"
+	"hse-dss-efimov/replay"
+	"hse-dss-efimov/shrink"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var shrinkCmd = &cobra.Command{
+	Use:   "shrink JOURNAL",
+	Short: "Reduces decisions of a failing journal to a minimal set still making the system fail",
+	Long: "Replays JOURNAL against the system restarted for every run, enforcing subsets of the recorded " +
+		"decisions chosen by delta debugging; messages whose decisions are left out are accepted on arrival. " +
+		"Every decision may drop, duplicate, modify or inject its message, or hold it back behind decisions " +
+		"recorded ahead of it. The smallest set of decisions for which the check still fails is written as a " +
+		"journal, which can be replayed with replay --lenient.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("Command requires JOURNAL argument")
+			os.Exit(-1)
+		}
+
+		header, records, err := journal.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("Cannot read journal: %v\n", err)
+			os.Exit(-1)
+		}
+		port_pairs, err := journalPorts(header)
+		if err != nil {
+			fmt.Printf("Cannot parse journal header: %v\n", err)
+			os.Exit(-1)
+		}
+
+		flags := cmd.Flags()
+		output, _ := flags.GetString("output")
+		if output == "" {
+			output = args[0] + ".min"
+		}
+		os.Exit(mainShrink(cmd, header, records, port_pairs, output))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(shrinkCmd)
+
+	flags := shrinkCmd.Flags()
+	addSystemFlags(flags)
+	flags.String("output", "", "path of the shrunk journal (default: JOURNAL.min)")
+	flags.Int("attempts", 1, "number of runs for every set of decisions, any failing one counts, for flaky systems")
+	flags.Duration("idle-timeout", 2*time.Second, "skip a decision if its message does not arrive for this long")
+	flags.Duration("pace", 100*time.Millisecond, "minimum interval between decisions, letting decided messages reach their destinations")
+	flags.Duration("settle", 2*time.Second, "time given to the system after replay before the check")
+}
+
+// Describes decision on the given Message recorded in the journal.
+func describeDecision(records []journal.Record, seqnum uint64) string {
+	var decision, modified, injected string
+	for _, record := range records {
+		if record.Seqnum != seqnum || record.Origin != 0 {
+			continue
+		}
+		switch record.Kind {
+		case journal.KindReceive:
+			if record.Injected {
+				injected = "injected "
+			}
+		case journal.KindModify:
+			modified = "modified, "
+		case journal.KindDecide:
+			switch record.Copies {
+			case 0:
+				decision = "rejected"
+			case 1:
+				decision = "accepted"
+			default:
+				decision = fmt.Sprintf("delivered %d times", record.Copies)
+			}
+			decision = fmt.Sprintf("%vmessage %d on channel %v from %v %v%v",
+				injected, seqnum, record.Channel, record.Src, modified, decision)
+		}
+	}
+	return decision
+}
+
+// Shrinks decisions of the journal; returns exit status.
+func mainShrink(cmd *cobra.Command, header journal.Record, records []journal.Record, port_pairs []ports,
+	output string) int {
+	logger, _ := zap.NewDevelopment()
+
+	flags := cmd.Flags()
+	system, err := newProcessSystem(cmd, logger, port_pairs, header.Seed, "")
+	if err != nil {
+		fmt.Println(err)
+		return -1
+	}
+	if system.check == "" {
+		fmt.Println("Check command must be given")
+		return -1
+	}
+	attempts, _ := flags.GetInt("attempts")
+	idle, _ := flags.GetDuration("idle-timeout")
+	settle, _ := flags.GetDuration("settle")
+	pace, _ := flags.GetDuration("pace")
+
+	// Once interrupted, runs in progress finish and the rest count as passing, so that the
+	// smallest failing set found so far gets written.
+	var stopped int32
+	q := make(chan os.Signal, 1)
+	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		logger.Info("stopping shrinking", zap.Stringer("signal", <-q))
+		atomic.StoreInt32(&stopped, 1)
+	}()
+
+	runs := 0
+	fails := func(kept []uint64) bool {
+		for attempt := 0; attempt < attempts && atomic.LoadInt32(&stopped) == 0; attempt++ {
+			runs++
+			channels, err := system.Start(runs)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			replayer := replay.NewReplayer(shrink.Keep(records, kept), channels, *logger)
+			replayer.SetLenient()
+			replayer.SetPace(pace)
+			system.tb.setInterceptor(replayer)
+			replayer.Start()
+			replayer.Wait(idle)
+			time.Sleep(settle)
+			violation := system.Check()
+			system.Stop()
+
+			logger.Info("tested decisions", zap.Int("run", runs), zap.Int("decisions", len(kept)),
+				zap.Int("skipped", replayer.Skipped()), zap.Bool("fails", violation != nil))
+			if violation != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	decisions := shrink.Decisions(records)
+	if !fails(decisions) {
+		if atomic.LoadInt32(&stopped) != 0 {
+			fmt.Println("Shrinking interrupted")
+		} else {
+			fmt.Println("System does not fail when replaying the journal")
+		}
+		return 1
+	}
+	minimal := shrink.Minimize(decisions, fails)
+
+	if err := journal.WriteFile(output, header, shrink.Keep(records, minimal)); err != nil {
+		fmt.Printf("Cannot write shrunk journal: %v\n", err)
+		return -1
+	}
+	if atomic.LoadInt32(&stopped) != 0 {
+		fmt.Println("Shrinking interrupted, result may not be minimal")
+	}
+	fmt.Printf("Shrunk %d decisions to %d in %d runs, written to %v\n", len(decisions), len(minimal), runs, output)
+	for _, seqnum := range minimal {
+		fmt.Printf("  %v\n", describeDecision(records, seqnum))
+	}
+	return 0
+}
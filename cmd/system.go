@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func addSystemFlags(flags *pflag.FlagSet) {
+	flags.String("system", "", "shell command starting nodes of the system under test")
+	flags.String("check", "", "shell command checking the system after every run, failing on violation")
+	flags.Duration("startup", 500*time.Millisecond, "time given to the system to start listening before channels are established")
+}
+
+// Builds system from the flags added by addSystemFlags; non-empty journalPath
+// makes every run recorded in a journal of its own.
+func newProcessSystem(cmd *cobra.Command, logger *zap.Logger, port_pairs []ports, seed int64,
+	journalPath string) (*processSystem, error) {
+	flags := cmd.Flags()
+	command, _ := flags.GetString("system")
+	if command == "" {
+		return nil, fmt.Errorf("system command must be given")
+	}
+	check, _ := flags.GetString("check")
+	startup, _ := flags.GetDuration("startup")
+	return &processSystem{
+		logger:      logger,
+		port_pairs:  port_pairs,
+		seed:        seed,
+		journalPath: journalPath,
+		command:     command,
+		check:       check,
+		startup:     startup,
+	}, nil
+}
+
+// System run by a shell command behind freshly established channels.
+type processSystem struct {
+	logger      *zap.Logger
+	port_pairs  []ports
+	seed        int64
+	journalPath string
+	command     string
+	check       string
+	// Command printing state of the nodes, if any.
+	state   string
+	startup time.Duration
+
+	tb      *testbed
+	process *exec.Cmd
+}
+
+// Returns path of the journal of the given execution, if journal is requested.
+func (s *processSystem) journalFor(execution int) string {
+	if s.journalPath == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v.%d", s.journalPath, execution)
+}
+
+// Starts the system ahead of channels, so that its nodes listen on destination
+// ports by the time channels connect to them; nodes are expected to retry
+// connecting to source ports.
+func (s *processSystem) Start(execution int) ([]network.Channel, error) {
+	s.process = exec.Command("sh", "-c", s.command)
+	// Own process group lets Stop kill whatever the command spawns.
+	s.process.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := s.process.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start system: %v", err)
+	}
+	s.logger.Debug("started system", zap.Int("execution", execution), zap.Int("pid", s.process.Process.Pid))
+	time.Sleep(s.startup)
+	s.tb = newTestbed(s.port_pairs, s.seed, s.journalFor(execution), s.logger)
+	return s.tb.channels(), nil
+}
+
+func (s *processSystem) Check() error {
+	if s.check == "" {
+		return nil
+	}
+	output, err := exec.Command("sh", "-c", s.check).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %v", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Closes channels ahead of the system, so that they do not reconnect to killed nodes.
+func (s *processSystem) Stop() {
+	s.tb.close()
+	syscall.Kill(-s.process.Process.Pid, syscall.SIGKILL)
+	s.process.Wait()
+}
+
+// Returns output of the state command.
+func (s *processSystem) State() (string, error) {
+	output, err := exec.Command("sh", "-c", s.state).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"hse-dss-efimov/journal"
+	"hse-dss-efimov/network"
+	"hse-dss-efimov/store"
+	"hse-dss-efimov/websocket"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Channels configured from flags and config file, along with the state shared
+// by the web interface. Every channel decides on messages with partitions and
+// the fault model configured for it, handing the rest out to the web interface.
+type testbed struct {
+	logger      *zap.Logger
+	msgDbChan   *websocket.Chans_ports
+	dispatcher  *websocket.Dispatcher
+	faultModels []*network.FaultModel
+	// Interceptors deciding ahead of fault models, such as nemesis.
+	interceptors network.InterceptorChain
+	journal      *journal.Writer
+}
+
+// Returns configured random seed, drawing one if none is set.
+func configuredSeed(logger *zap.Logger) int64 {
+	seed := viper.GetInt64("seed")
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.Info("using random seed", zap.Int64("seed", seed))
+	return seed
+}
+
+// Establishes channels between port pairs; non-empty journalPath makes them recorded in the journal.
+func newTestbed(port_pairs []ports, seed int64, journalPath string, logger *zap.Logger) *testbed {
+	tb := &testbed{logger: logger}
+	tb.dispatcher = websocket.NewDispatcher(*logger, func(ctx websocket.CallCtx) {
+		logger.Debug("handling call", zap.Any("data", ctx.Data()))
+	})
+
+	msg_db_chan := &websocket.Chans_ports{Store: store.NewStore(), MsgChan: make(chan network.Message, 100)}
+	tb.msgDbChan = msg_db_chan
+	tb.dispatcher.Forward(msg_db_chan)
+
+	faultModels := make([]*network.FaultModel, len(port_pairs))
+	reorders := make([]network.Reorder, len(port_pairs))
+	shapings := make([]network.Shaping, len(port_pairs))
+	pendingCapacities := make([]int, len(port_pairs))
+	overflowPolicies := make([]network.OverflowPolicy, len(port_pairs))
+	for i, pair := range port_pairs {
+		faultModel, err := faultModelFor(channelName(pair), seed+int64(i))
+		if err != nil {
+			fmt.Printf("Cannot configure faults of channel %v: %v", channelName(pair), err)
+			os.Exit(-1)
+		}
+		faultModels[i] = faultModel
+
+		reorders[i], err = reorderFor(channelName(pair), seed+int64(i))
+		if err != nil {
+			fmt.Printf("Cannot configure reordering of channel %v: %v", channelName(pair), err)
+			os.Exit(-1)
+		}
+
+		shapings[i], err = shapingFor(channelName(pair))
+		if err != nil {
+			fmt.Printf("Cannot configure shaping of channel %v: %v", channelName(pair), err)
+			os.Exit(-1)
+		}
+
+		pendingCapacities[i], overflowPolicies[i], err = pendingLimitFor(channelName(pair))
+		if err != nil {
+			fmt.Printf("Cannot configure pending queue of channel %v: %v", channelName(pair), err)
+			os.Exit(-1)
+		}
+	}
+	tb.faultModels = faultModels
+
+	partitionPolicy, err := network.ParsePartitionPolicy(viper.GetString("partition.policy"))
+	if err != nil {
+		fmt.Printf("Cannot configure partitions: %v", err)
+		os.Exit(-1)
+	}
+	msg_db_chan.Partitions = network.NewPartitions(partitionPolicy, *logger)
+
+	observers := network.Observers{msg_db_chan.Store, tb.dispatcher.CopyObserver()}
+	if journalPath != "" {
+		names := make([]string, len(port_pairs))
+		for i, pair := range port_pairs {
+			names[i] = channelName(pair)
+		}
+		tb.journal, err = journal.Create(journalPath, seed, names)
+		if err != nil {
+			fmt.Printf("Cannot create journal: %v", err)
+			os.Exit(-1)
+		}
+		logger.Info("writing journal", zap.String("path", journalPath))
+		observers = append(observers, tb.journal)
+	}
+
+	counter := uint64(0)
+	for i, pair := range port_pairs {
+		channel := network.NewChannel(channelName(pair), pair.src, pair.dst, &counter, *logger, msg_db_chan.MsgChan)
+		channel.SetPendingLimit(pendingCapacities[i], overflowPolicies[i])
+		channel.SetObserver(observers)
+		if faultModel := faultModels[i]; faultModel != nil {
+			logger.Info("injecting faults",
+				zap.String("channel", channel.GetName()),
+				zap.Float64("drop", faultModel.DropProbability),
+				zap.Float64("duplicate", faultModel.DuplicateProbability),
+				zap.Any("delay", faultModel.Delay))
+		}
+		if reorder := reorders[i]; reorder.Mode != network.ReorderNone {
+			logger.Info("reordering messages",
+				zap.String("channel", channel.GetName()),
+				zap.Stringer("mode", reorder.Mode),
+				zap.Int("window", reorder.Window),
+				zap.Duration("timeout", reorder.Timeout))
+			channel.SetReorder(reorder)
+		}
+		if shaping := shapings[i]; shaping != (network.Shaping{}) {
+			logger.Info("shaping traffic",
+				zap.String("channel", channel.GetName()),
+				zap.Int64("bandwidth", shaping.Bandwidth),
+				zap.Int64("burst", shaping.Burst),
+				zap.Duration("frameLatency", shaping.FrameLatency),
+				zap.Duration("byteLatency", shaping.ByteLatency))
+			channel.SetShaping(shaping)
+		}
+		msg_db_chan.Channels = append(msg_db_chan.Channels, channel)
+	}
+	tb.setManual()
+	return tb
+}
+
+// Returns channels in the order of port pairs.
+func (tb *testbed) channels() []network.Channel {
+	return tb.msgDbChan.Channels
+}
+
+// Restores default deciding: partitions, added interceptors and fault models first, the web interface for the rest.
+func (tb *testbed) setManual() {
+	for i, channel := range tb.channels() {
+		interceptors := append(network.InterceptorChain{tb.msgDbChan.Partitions}, tb.interceptors...)
+		if faultModel := tb.faultModels[i]; faultModel != nil {
+			interceptors = append(interceptors, faultModel)
+		}
+		channel.SetInterceptor(interceptors)
+	}
+}
+
+// Adds interceptor to default deciding, right after partitions.
+func (tb *testbed) addInterceptor(interceptor network.Interceptor) {
+	tb.interceptors = append(tb.interceptors, interceptor)
+	tb.setManual()
+}
+
+// Decides on messages of every channel with the given interceptor instead.
+func (tb *testbed) setInterceptor(interceptor network.Interceptor) {
+	for _, channel := range tb.channels() {
+		channel.SetInterceptor(interceptor)
+	}
+}
+
+func (tb *testbed) serveWeb(webport int) {
+	if webport <= 0 {
+		tb.logger.Debug("http server is not serving")
+		return
+	}
+	logger := tb.logger
+	m := http.NewServeMux()
+	// m.HandleFunc("/", httpRootHandler)
+	m.Handle("/web/", http.StripPrefix("/web/", http.FileServer(http.Dir("./web"))))
+	m.Handle("/images/", http.StripPrefix("/images/", http.FileServer(http.Dir("./images"))))
+	m.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		websocket.HttpHandler(tb.dispatcher, w, r, tb.msgDbChan)
+	})
+	go func() {
+		logger.Debug("http server is serving on port " + strconv.Itoa(webport))
+		a := ":" + strconv.Itoa(webport)
+		h := httpWrapperHandler{*logger, m}
+		if err := http.ListenAndServe(a, h); err != nil {
+			logger.Panic("failed to listen and serve http", zap.Error(err))
+		}
+	}()
+}
+
+// Closes the web interface, then channels, then the journal, so that it records every event.
+func (tb *testbed) close() {
+	tb.dispatcher.Close()
+	channels := tb.channels()
+	for i := len(channels) - 1; i >= 0; i-- {
+		channels[i].Close()
+	}
+	if tb.journal != nil {
+		if err := tb.journal.Close(); err != nil {
+			tb.logger.Error("failed to write journal", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package explore
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"sort"
+	"time"
+)
+
+// Delivery of a Message, identified across executions by the direction of the
+// channel it travels through and its arrival index within it.
+type Event struct {
+	Channel string
+	Src     string
+	Dst     string
+	Index   int
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v:%v->%v#%d", e.Channel, e.Src, e.Dst, e.Index)
+}
+
+// Deliveries to different destinations commute.
+func (e Event) independent(other Event) bool {
+	return e.Dst != other.Dst
+}
+
+func contains(events []Event, e Event) bool {
+	for _, other := range events {
+		if other == e {
+			return true
+		}
+	}
+	return false
+}
+
+// System under exploration, restarted for every execution.
+type System interface {
+	// Starts a fresh instance of the system for the given execution, returning its channels.
+	Start(execution int) ([]network.Channel, error)
+	// Checks state of the system once the execution is over; returns error describing violation.
+	Check() error
+	// Stops the instance started last.
+	Stop()
+}
+
+// Execution run to completion.
+type Execution struct {
+	Number   int
+	Schedule []Event
+	// Violation reported by the check, if any.
+	Err error
+}
+
+// Outcome of a systematic exploration.
+type DFSResult struct {
+	// Executions run to completion, no two of them equivalent.
+	Executions int
+	// Executions abandoned once they turned out to be equivalent to explored ones.
+	Pruned int
+	// Executions abandoned since the system did not reproduce the prefix to extend.
+	Nondeterministic int
+	Violations       []Execution
+	// Set if every distinct execution was explored.
+	Complete bool
+}
+
+// Point of choice among enabled deliveries.
+type node struct {
+	enabled []Event
+	// Deliveries known to lead to executions equivalent to explored ones.
+	sleep []Event
+	// Choices explored already.
+	done   []Event
+	choice Event
+}
+
+type outcome int
+
+const (
+	outcomeFinished outcome = iota
+	outcomePruned
+	outcomeNondeterministic
+	outcomeStopped
+)
+
+// Assigns events to live messages as they show up.
+type tracker struct {
+	events map[uint64]Event
+	counts map[flow]int
+}
+
+type flow struct {
+	channel string
+	src     string
+}
+
+func newTracker() *tracker {
+	return &tracker{events: make(map[uint64]Event), counts: make(map[flow]int)}
+}
+
+// Returns pending messages in order of arrival along with their events.
+func (t *tracker) track(pending []network.MessageI) ([]network.MessageI, []Event) {
+	sort.Slice(pending, func(i, j int) bool { return pending[i].GetSeqNum() < pending[j].GetSeqNum() })
+	events := make([]Event, len(pending))
+	for i, msg := range pending {
+		e, ok := t.events[msg.GetSeqNum()]
+		if !ok {
+			f := flow{msg.GetChannel(), msg.GetSrc()}
+			e = Event{Channel: f.channel, Src: f.src, Dst: msg.GetDst(), Index: t.counts[f]}
+			t.counts[f]++
+			t.events[msg.GetSeqNum()] = e
+		}
+		events[i] = e
+	}
+	return pending, events
+}
+
+// Enumerates delivery orders of pending messages depth first, restarting the
+// system for every execution. Sleep sets prune executions which differ from
+// explored ones only in the order of commuting deliveries.
+type DFS struct {
+	logger        zap.Logger
+	system        System
+	step          time.Duration
+	quiescence    time.Duration
+	maxSteps      int
+	maxExecutions int
+	// Choices of the execution being explored.
+	stack []*node
+}
+
+// Creates explorer pausing for step after every delivery and ending an execution
+// once nothing is pending for quiescence or it has maxSteps deliveries. Zero
+// maxSteps and maxExecutions stand for no limit.
+func NewDFS(system System, step time.Duration, quiescence time.Duration, maxSteps int, maxExecutions int,
+	logger zap.Logger) *DFS {
+	return &DFS{
+		logger:        logger,
+		system:        system,
+		step:          step,
+		quiescence:    quiescence,
+		maxSteps:      maxSteps,
+		maxExecutions: maxExecutions,
+	}
+}
+
+// Explores until every distinct execution is explored, execution limit is
+// reached or stopCh is closed. Returns error if the system fails to start.
+func (d *DFS) Run(stopCh <-chan struct{}) (DFSResult, error) {
+	var result DFSResult
+	for number := 1; d.maxExecutions == 0 || result.Executions < d.maxExecutions; number++ {
+		channels, err := d.system.Start(number)
+		if err != nil {
+			return result, err
+		}
+		o, schedule := d.execute(channels, stopCh)
+		var violation error
+		if o == outcomeFinished {
+			violation = d.system.Check()
+		}
+		d.system.Stop()
+
+		switch o {
+		case outcomeStopped:
+			return result, nil
+		case outcomePruned:
+			result.Pruned++
+		case outcomeNondeterministic:
+			result.Nondeterministic++
+		case outcomeFinished:
+			result.Executions++
+			d.logger.Info("execution finished", zap.Int("execution", number), zap.Int("steps", len(schedule)))
+			if violation != nil {
+				d.logger.Error("execution violated check", zap.Int("execution", number), zap.Error(violation))
+				result.Violations = append(result.Violations, Execution{number, schedule, violation})
+			}
+		}
+		if !d.backtrack() {
+			result.Complete = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// Replays the choices on the stack, then extends them with the first choices
+// not asleep. Returns the schedule of the execution.
+func (d *DFS) execute(channels []network.Channel, stopCh <-chan struct{}) (outcome, []Event) {
+	t := newTracker()
+	var schedule []Event
+	for depth := 0; d.maxSteps == 0 || depth < d.maxSteps; depth++ {
+		var want *Event
+		if depth < len(d.stack) {
+			want = &d.stack[depth].choice
+		}
+		pending, events, o := await(channels, t, want, d.quiescence, stopCh)
+		if o == outcomeStopped {
+			return o, schedule
+		}
+		if len(pending) == 0 {
+			if want != nil {
+				d.logger.Warn("system did not reproduce the prefix", zap.Stringer("event", *want))
+				return outcomeNondeterministic, schedule
+			}
+			break
+		}
+
+		var n *node
+		if want != nil {
+			n = d.stack[depth]
+			for _, e := range events {
+				if !contains(n.enabled, e) {
+					n.enabled = append(n.enabled, e)
+				}
+			}
+		} else {
+			n = &node{enabled: events}
+			if depth > 0 {
+				parent := d.stack[depth-1]
+				for _, e := range append(append([]Event(nil), parent.sleep...), parent.done...) {
+					if e.independent(parent.choice) && contains(events, e) && !contains(n.sleep, e) {
+						n.sleep = append(n.sleep, e)
+					}
+				}
+			}
+			found := false
+			for _, e := range events {
+				if !contains(n.sleep, e) {
+					n.choice, found = e, true
+					break
+				}
+			}
+			if !found {
+				return outcomePruned, schedule
+			}
+			d.stack = append(d.stack, n)
+		}
+
+		for i, e := range events {
+			if e == n.choice {
+				pending[i].Accept()
+				break
+			}
+		}
+		schedule = append(schedule, n.choice)
+
+		select {
+		case <-stopCh:
+			return outcomeStopped, schedule
+		case <-time.After(d.step):
+		}
+	}
+	return outcomeFinished, schedule
+}
+
+// Waits for the wanted Message to show up, or for any Message if want is nil.
+// Returns no messages if none shows up within quiescence.
+func await(channels []network.Channel, t *tracker, want *Event, quiescence time.Duration, stopCh <-chan struct{}) (
+	[]network.MessageI, []Event, outcome) {
+	deadline := time.Now().Add(quiescence)
+	for {
+		pending, events := t.track(pendingOf(channels))
+		if (want == nil && len(pending) > 0) || (want != nil && contains(events, *want)) {
+			return pending, events, outcomeFinished
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, outcomeFinished
+		}
+		select {
+		case <-stopCh:
+			return nil, nil, outcomeStopped
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Marks choices of the execution explored and picks the deepest unexplored one.
+// Returns false once every distinct execution is explored.
+func (d *DFS) backtrack() bool {
+	for len(d.stack) > 0 {
+		top := d.stack[len(d.stack)-1]
+		top.done = append(top.done, top.choice)
+		for _, e := range top.enabled {
+			if !contains(top.done, e) && !contains(top.sleep, e) {
+				top.choice = e
+				return true
+			}
+		}
+		d.stack = d.stack[:len(d.stack)-1]
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package explore
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"testing"
+	"time"
+)
+
+// Delivers one message through each of channels a and b to node X and one
+// through channel c to node Y; check fails if b is delivered before a.
+type fakeSystem struct {
+	accepted []uint64
+	started  int
+	stopped  int
+}
+
+func (s *fakeSystem) Start(execution int) ([]network.Channel, error) {
+	s.started++
+	s.accepted = nil
+	var channels []network.Channel
+	for i, name := range []string{"a", "b", "c"} {
+		c := &fakeChannel{name: name, dst: "X", accepted: &s.accepted}
+		if name == "c" {
+			c.dst = "Y"
+		}
+		c.add(uint64(i + 1))
+		channels = append(channels, c)
+	}
+	return channels, nil
+}
+
+func (s *fakeSystem) Check() error {
+	for _, seqnum := range s.accepted {
+		if seqnum == 1 {
+			return nil
+		}
+		if seqnum == 2 {
+			return errors.New("b overtook a")
+		}
+	}
+	return nil
+}
+
+func (s *fakeSystem) Stop() {
+	s.stopped++
+}
+
+func TestDFSPrunesCommutingDeliveries(t *testing.T) {
+	system := &fakeSystem{}
+	d := NewDFS(system, 0, 20*time.Millisecond, 0, 0, *zap.NewNop())
+	result, err := d.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Of 6 delivery orders only the relative order of a and b matters.
+	if !result.Complete || result.Executions != 2 || result.Nondeterministic != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if system.started != result.Executions+result.Pruned || system.stopped != system.started {
+		t.Errorf("system started %v times and stopped %v times for %+v", system.started, system.stopped, result)
+	}
+	if len(result.Violations) != 1 {
+		t.Fatalf("unexpected violations: %+v", result.Violations)
+	}
+	schedule := result.Violations[0].Schedule
+	if len(schedule) != 3 || schedule[0].Channel != "b" || schedule[1].Channel != "a" {
+		t.Errorf("unexpected schedule of violation: %v", schedule)
+	}
+}
+
+func TestDFSMaxExecutions(t *testing.T) {
+	d := NewDFS(&fakeSystem{}, 0, 20*time.Millisecond, 0, 1, *zap.NewNop())
+	result, err := d.Run(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Complete || result.Executions != 1 || len(result.Violations) != 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
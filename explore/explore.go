@@ -0,0 +1,95 @@
+package explore
+
+import (
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"time"
+)
+
+// Interval of polling channels for pending messages while there are none.
+const pollInterval = 10 * time.Millisecond
+
+// Chooses the Message to deliver next. Describes itself for reports.
+type Strategy interface {
+	// Returns index of the Message to accept among pending ones; pending is never empty.
+	Pick(pending []network.MessageI) int
+	String() string
+}
+
+// Outcome of an exploration.
+type Result struct {
+	// Number of messages accepted.
+	Steps int
+	// Set if exploration stopped because no Message was pending for the quiescence period.
+	Quiescent bool
+}
+
+// Drives channels by repeatedly accepting one of the messages pending in any of
+// them, as chosen by the strategy.
+type Explorer struct {
+	logger     zap.Logger
+	channels   []network.Channel
+	strategy   Strategy
+	step       time.Duration
+	quiescence time.Duration
+	maxSteps   int
+}
+
+// Creates explorer pausing for step after every acceptance, so that nodes get to
+// react, and stopping once nothing is pending for quiescence or maxSteps messages
+// are accepted; zero maxSteps stands for no limit.
+func NewExplorer(channels []network.Channel, strategy Strategy, step time.Duration, quiescence time.Duration,
+	maxSteps int, logger zap.Logger) *Explorer {
+	return &Explorer{
+		logger:     logger,
+		channels:   channels,
+		strategy:   strategy,
+		step:       step,
+		quiescence: quiescence,
+		maxSteps:   maxSteps,
+	}
+}
+
+// Returns messages pending in all channels, in order of the channels.
+func pendingOf(channels []network.Channel) []network.MessageI {
+	var pending []network.MessageI
+	for _, channel := range channels {
+		pending = append(pending, channel.Pending()...)
+	}
+	return pending
+}
+
+// Explores until the system quiesces, step limit is reached or stopCh is closed.
+func (e *Explorer) Run(stopCh <-chan struct{}) Result {
+	var result Result
+	idleSince := time.Now()
+	for e.maxSteps == 0 || result.Steps < e.maxSteps {
+		pending := pendingOf(e.channels)
+		wait := e.step
+		if len(pending) == 0 {
+			if time.Since(idleSince) >= e.quiescence {
+				result.Quiescent = true
+				break
+			}
+			wait = pollInterval
+		} else {
+			msg := pending[e.strategy.Pick(pending)]
+			e.logger.Debug("accepting message",
+				zap.Int("step", result.Steps),
+				zap.String("channel", msg.GetChannel()),
+				zap.Uint64("seqnum", msg.GetSeqNum()),
+				zap.Int("pending", len(pending)))
+			msg.Accept()
+			result.Steps++
+		}
+		select {
+		case <-stopCh:
+			return result
+		case <-time.After(wait):
+		}
+		if len(pending) > 0 {
+			idleSince = time.Now()
+		}
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package explore
+
+import (
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Channel whose pending messages leave it once decided on, recording acceptance order.
+type fakeChannel struct {
+	network.Channel
+	name     string
+	dst      string
+	mu       sync.Mutex
+	pending  []network.MessageI
+	accepted *[]uint64
+}
+
+func newFakeChannel(accepted *[]uint64, seqnums ...uint64) *fakeChannel {
+	c := &fakeChannel{accepted: accepted}
+	for _, seqnum := range seqnums {
+		c.add(seqnum)
+	}
+	return c
+}
+
+func (c *fakeChannel) add(seqnum uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := &network.Message{Seqnum: seqnum, Channel: c.name, Src: c.name, Dst: c.dst, Payload: []byte(c.name)}
+	msg.DecideFn = func(copies int) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for i, m := range c.pending {
+			if m == msg {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+		*c.accepted = append(*c.accepted, seqnum)
+	}
+	c.pending = append(c.pending, msg)
+}
+
+func (c *fakeChannel) Pending() []network.MessageI {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]network.MessageI(nil), c.pending...)
+}
+
+func explore(seed int64, maxSteps int) ([]uint64, Result) {
+	var accepted []uint64
+	channels := []network.Channel{newFakeChannel(&accepted, 1, 2, 3), newFakeChannel(&accepted, 4, 5)}
+	e := NewExplorer(channels, NewRandom(seed), 0, 20*time.Millisecond, maxSteps, *zap.NewNop())
+	return accepted, e.Run(nil)
+}
+
+func TestExploreRandomIsReproducible(t *testing.T) {
+	first, result := explore(7, 0)
+	if !result.Quiescent || result.Steps != 5 || len(first) != 5 {
+		t.Fatalf("unexpected result %+v, accepted %v", result, first)
+	}
+	second, _ := explore(7, 0)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed accepted messages in different orders: %v, %v", first, second)
+	}
+}
+
+func TestExploreMaxSteps(t *testing.T) {
+	accepted, result := explore(7, 2)
+	if result.Quiescent || result.Steps != 2 || len(accepted) != 2 {
+		t.Errorf("unexpected result %+v, accepted %v", result, accepted)
+	}
+}
+
+func TestExploreWaitsForLateMessages(t *testing.T) {
+	var accepted []uint64
+	channel := newFakeChannel(&accepted)
+	time.AfterFunc(10*time.Millisecond, func() { channel.add(1) })
+	e := NewExplorer([]network.Channel{channel}, NewRandom(1), 0, 100*time.Millisecond, 0, *zap.NewNop())
+	if result := e.Run(nil); result.Steps != 1 || !result.Quiescent {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestExploreStops(t *testing.T) {
+	var accepted []uint64
+	stopCh := make(chan struct{})
+	close(stopCh)
+	e := NewExplorer([]network.Channel{newFakeChannel(&accepted)}, NewRandom(1), 0, time.Hour, 0, *zap.NewNop())
+	if result := e.Run(stopCh); result.Steps != 0 || result.Quiescent {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
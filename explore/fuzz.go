@@ -0,0 +1,217 @@
+package explore
+
+import (
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"math/rand"
+	"time"
+)
+
+// Decision on a Message of a schedule.
+type Decision struct {
+	Event Event
+	Drop  bool `json:",omitempty"`
+	// Number of steps the Message is held back for once it is pending.
+	Delay int `json:",omitempty"`
+}
+
+// System reporting states of its nodes, such as a mutex being held.
+type StateReporter interface {
+	// Returns current state of the nodes of the system.
+	State() (string, error)
+}
+
+// Run of the system under a schedule.
+type Iteration struct {
+	Number int
+	// Decisions made, in order; delays are reflected in the order.
+	Schedule []Decision
+	// Number of coverage features first reached in the iteration.
+	NewCoverage int
+	// Violation reported by the check, if any.
+	Err error
+}
+
+// Greybox fuzzer of schedules. Every iteration runs the system under a mutant
+// of a schedule from the corpus; schedules reaching coverage features no other
+// one did join the corpus. Features are states reported by the system, if it
+// is a StateReporter, and pairs of types of consecutive messages delivered to
+// every destination.
+type Fuzzer struct {
+	logger     zap.Logger
+	system     System
+	rng        *rand.Rand
+	step       time.Duration
+	quiescence time.Duration
+	maxSteps   int
+	typeOf     func(payload []byte) string
+
+	corpus     [][]Decision
+	coverage   map[string]bool
+	iterations int
+}
+
+// Creates fuzzer pausing for step after every decision and ending an iteration
+// once nothing is pending for quiescence or it has maxSteps decisions; zero
+// maxSteps stands for no limit. Corpus starts with the empty schedule, which
+// delivers messages in order of arrival.
+func NewFuzzer(system System, seed int64, step time.Duration, quiescence time.Duration, maxSteps int,
+	typeOf func(payload []byte) string, logger zap.Logger) *Fuzzer {
+	return &Fuzzer{
+		logger:     logger,
+		system:     system,
+		rng:        rand.New(rand.NewSource(seed)),
+		step:       step,
+		quiescence: quiescence,
+		maxSteps:   maxSteps,
+		typeOf:     typeOf,
+		corpus:     [][]Decision{nil},
+		coverage:   make(map[string]bool),
+	}
+}
+
+// Adds schedule to the corpus, such as one saved by an earlier run.
+func (f *Fuzzer) AddCorpus(schedule []Decision) {
+	f.corpus = append(f.corpus, schedule)
+}
+
+func (f *Fuzzer) CorpusSize() int {
+	return len(f.corpus)
+}
+
+// Returns number of coverage features reached so far.
+func (f *Fuzzer) Coverage() int {
+	return len(f.coverage)
+}
+
+// Runs the system under a mutant of a schedule from the corpus; the first
+// iteration runs the empty schedule as is. Returns error if the system fails to start.
+func (f *Fuzzer) Iterate() (Iteration, error) {
+	f.iterations++
+	schedule := f.corpus[f.rng.Intn(len(f.corpus))]
+	if f.iterations > 1 {
+		schedule = f.mutate(schedule)
+	}
+
+	channels, err := f.system.Start(f.iterations)
+	if err != nil {
+		return Iteration{}, err
+	}
+	executed, features := f.execute(channels, schedule)
+	violation := f.system.Check()
+	f.system.Stop()
+
+	iteration := Iteration{Number: f.iterations, Schedule: executed, Err: violation}
+	for feature := range features {
+		if !f.coverage[feature] {
+			f.coverage[feature] = true
+			iteration.NewCoverage++
+		}
+	}
+	if iteration.NewCoverage > 0 {
+		f.corpus = append(f.corpus, executed)
+	}
+	return iteration, nil
+}
+
+// Swaps two decisions, drops a Message or delays one, up to three times over.
+func (f *Fuzzer) mutate(schedule []Decision) []Decision {
+	mutant := append([]Decision(nil), schedule...)
+	if len(mutant) == 0 {
+		return mutant
+	}
+	for n := 1 + f.rng.Intn(3); n > 0; n-- {
+		i := f.rng.Intn(len(mutant))
+		switch f.rng.Intn(3) {
+		case 0:
+			j := f.rng.Intn(len(mutant))
+			mutant[i], mutant[j] = mutant[j], mutant[i]
+		case 1:
+			mutant[i].Drop = true
+		case 2:
+			mutant[i].Delay += 1 + f.rng.Intn(3)
+		}
+	}
+	return mutant
+}
+
+// Decides on pending messages by the schedule: the Message listed first among
+// those not held back goes first, messages the schedule does not list go last in
+// order of arrival. A Message delayed by n is held back for n steps since it
+// arrived, idle steps included. Returns decisions made and coverage features reached.
+func (f *Fuzzer) execute(channels []network.Channel, schedule []Decision) ([]Decision, map[string]bool) {
+	position := make(map[Event]int)
+	for i, d := range schedule {
+		if _, ok := position[d.Event]; !ok {
+			position[d.Event] = i
+		}
+	}
+	rank := func(e Event) int {
+		if i, ok := position[e]; ok {
+			return i
+		}
+		return len(schedule)
+	}
+	delay := func(e Event) int {
+		if i, ok := position[e]; ok {
+			return schedule[i].Delay
+		}
+		return 0
+	}
+
+	t := newTracker()
+	features := make(map[string]bool)
+	pendingSince := make(map[Event]int)
+	lastType := make(map[string]string)
+	reporter, reports := f.system.(StateReporter)
+	var executed []Decision
+	for step := 0; f.maxSteps == 0 || step < f.maxSteps; step++ {
+		pending, events, _ := await(channels, t, nil, f.quiescence, nil)
+		if len(pending) == 0 {
+			break
+		}
+
+		best := -1
+		bestHeld := false
+		for i, e := range events {
+			if _, ok := pendingSince[e]; !ok {
+				pendingSince[e] = step
+			}
+			held := step-pendingSince[e] < delay(e)
+			if best < 0 || (!held && bestHeld) || (held == bestHeld && rank(e) < rank(events[best])) {
+				best, bestHeld = i, held
+			}
+		}
+
+		if bestHeld {
+			// Every pending Message is delayed; waits for others to arrive.
+			time.Sleep(f.step)
+			continue
+		}
+
+		msg, e := pending[best], events[best]
+		d := Decision{Event: e, Delay: delay(e)}
+		if i, ok := position[e]; ok {
+			d.Drop = schedule[i].Drop
+		}
+		if d.Drop {
+			msg.Reject()
+		} else {
+			msg.Accept()
+			typ := f.typeOf(msg.GetPayload())
+			features["sequence:"+e.Dst+":"+lastType[e.Dst]+">"+typ] = true
+			lastType[e.Dst] = typ
+		}
+		executed = append(executed, d)
+
+		time.Sleep(f.step)
+		if reports {
+			if state, err := reporter.State(); err != nil {
+				f.logger.Warn("cannot get state of the system", zap.Error(err))
+			} else {
+				features["state:"+state] = true
+			}
+		}
+	}
+	return executed, features
+}
@@ -0,0 +1,82 @@
+package explore
+
+import (
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func typeOfPayload(payload []byte) string {
+	return string(payload)
+}
+
+func TestFuzzerFindsViolation(t *testing.T) {
+	system := &fakeSystem{}
+	f := NewFuzzer(system, 1, 0, 10*time.Millisecond, 0, typeOfPayload, *zap.NewNop())
+
+	first, err := f.Iterate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Empty schedule delivers messages in order of arrival.
+	if first.Err != nil || len(first.Schedule) != 3 || first.Schedule[0].Event.Channel != "a" ||
+		first.Schedule[1].Event.Channel != "b" {
+		t.Fatalf("unexpected first iteration %+v", first)
+	}
+	// Sequences a>b to X and c to Y, each preceded by the start of the sequence.
+	if first.NewCoverage != 3 || f.CorpusSize() != 2 {
+		t.Errorf("unexpected coverage %v and corpus size %v", first.NewCoverage, f.CorpusSize())
+	}
+
+	for i := 0; i < 50; i++ {
+		iteration, err := f.Iterate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iteration.Err != nil {
+			// Fake system checks order of decisions, whether messages are dropped or not.
+			for _, d := range iteration.Schedule {
+				if d.Event.Channel == "b" {
+					return
+				}
+				if d.Event.Channel == "a" {
+					break
+				}
+			}
+			t.Fatalf("violation reported for schedule %+v", iteration.Schedule)
+		}
+	}
+	t.Error("no violation found")
+}
+
+func TestFuzzerIsReproducible(t *testing.T) {
+	run := func() [][]Decision {
+		f := NewFuzzer(&fakeSystem{}, 3, 0, 10*time.Millisecond, 0, typeOfPayload, *zap.NewNop())
+		var schedules [][]Decision
+		for i := 0; i < 10; i++ {
+			iteration, err := f.Iterate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			schedules = append(schedules, iteration.Schedule)
+		}
+		return schedules
+	}
+	if first, second := run(), run(); !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed produced different schedules: %v, %v", first, second)
+	}
+}
+
+func TestFuzzerMutantsHonorDelayAndDrop(t *testing.T) {
+	f := NewFuzzer(&fakeSystem{}, 1, 0, 10*time.Millisecond, 0, typeOfPayload, *zap.NewNop())
+	a := Event{Channel: "a", Src: "a", Dst: "X"}
+	b := Event{Channel: "b", Src: "b", Dst: "X"}
+	c := Event{Channel: "c", Src: "c", Dst: "Y"}
+	channels, _ := f.system.Start(1)
+	executed, _ := f.execute(channels, []Decision{{Event: a, Delay: 2}, {Event: b, Drop: true}, {Event: c}})
+	expected := []Decision{{Event: b, Drop: true}, {Event: c}, {Event: a, Delay: 2}}
+	if !reflect.DeepEqual(executed, expected) {
+		t.Errorf("expected %+v, got %+v", expected, executed)
+	}
+}
@@ -0,0 +1,124 @@
+package explore
+
+import (
+	"fmt"
+	"hse-dss-efimov/network"
+	"math/rand"
+	"sort"
+)
+
+// Entity PCT assigns priorities to.
+type Unit int
+
+const (
+	// Messages are prioritized by the node sending them.
+	UnitNode Unit = iota
+	// Messages are prioritized by the channel they travel through.
+	UnitChannel
+)
+
+var unitNames = map[Unit]string{
+	UnitNode:    "node",
+	UnitChannel: "channel",
+}
+
+func (u Unit) String() string {
+	if name, ok := unitNames[u]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unit(%d)", int(u))
+}
+
+func ParseUnit(s string) (Unit, error) {
+	for unit, name := range unitNames {
+		if name == s {
+			return unit, nil
+		}
+	}
+	return UnitNode, fmt.Errorf("unknown PCT unit %q", s)
+}
+
+// Probabilistic concurrency testing: every unit gets a random priority on first
+// sight and the oldest Message of the unit with the highest priority is delivered.
+// At depth-1 change points chosen at random among the first steps the unit about
+// to be delivered drops below all others, so that a bug of the given depth is
+// found with probability of at least 1/(n*steps^(depth-1)) for n units.
+type PCT struct {
+	rng          *rand.Rand
+	depth        int
+	unit         Unit
+	changePoints map[int]int // step to the priority assigned at it
+	priorities   map[string]float64
+	step         int
+}
+
+// Creates PCT scheduler for runs of about steps deliveries; depth must be positive.
+func NewPCT(seed int64, depth int, steps int, unit Unit) *PCT {
+	p := &PCT{
+		rng:          rand.New(rand.NewSource(seed)),
+		depth:        depth,
+		unit:         unit,
+		changePoints: make(map[int]int),
+		priorities:   make(map[string]float64),
+	}
+	// Demoting the unit at the very first step merely amounts to other initial priorities.
+	if steps < 2 {
+		steps = 2
+	}
+	for i := 1; i < depth; i++ {
+		p.changePoints[1+p.rng.Intn(steps-1)] = i
+	}
+	return p
+}
+
+func (p *PCT) Depth() int {
+	return p.depth
+}
+
+// Returns steps at which priorities change, in ascending order; fewer than depth-1
+// of them if random choices coincide.
+func (p *PCT) ChangePoints() []int {
+	steps := make([]int, 0, len(p.changePoints))
+	for step := range p.changePoints {
+		steps = append(steps, step)
+	}
+	sort.Ints(steps)
+	return steps
+}
+
+func (p *PCT) unitOf(msg network.MessageI) string {
+	if p.unit == UnitChannel {
+		return msg.GetChannel()
+	}
+	return msg.GetSrc()
+}
+
+// Returns index of the oldest Message of the unit with the highest priority.
+func (p *PCT) highest(pending []network.MessageI) int {
+	best := 0
+	for i, msg := range pending {
+		unit := p.unitOf(msg)
+		if _, ok := p.priorities[unit]; !ok {
+			// Initial priorities lie above the ones assigned at change points.
+			p.priorities[unit] = float64(p.depth) + p.rng.Float64()
+		}
+		if p.priorities[unit] > p.priorities[p.unitOf(pending[best])] {
+			best = i
+		}
+	}
+	return best
+}
+
+func (p *PCT) Pick(pending []network.MessageI) int {
+	best := p.highest(pending)
+	if priority, ok := p.changePoints[p.step]; ok {
+		p.priorities[p.unitOf(pending[best])] = float64(priority)
+		best = p.highest(pending)
+	}
+	p.step++
+	return best
+}
+
+func (p *PCT) String() string {
+	return fmt.Sprintf("PCT of depth %d by %v, changing priorities at steps %v", p.depth, p.unit, p.ChangePoints())
+}
@@ -0,0 +1,76 @@
+package explore
+
+import (
+	"hse-dss-efimov/network"
+	"testing"
+)
+
+func pendingFrom(srcs ...string) []network.MessageI {
+	pending := make([]network.MessageI, len(srcs))
+	for i, src := range srcs {
+		pending[i] = &network.Message{Seqnum: uint64(i + 1), Src: src, Channel: "c" + src}
+	}
+	return pending
+}
+
+// Delivers everything pending, returning sources in order of delivery.
+func drain(p *PCT, pending []network.MessageI) string {
+	var order string
+	for len(pending) > 0 {
+		i := p.Pick(pending)
+		order += pending[i].GetSrc()
+		pending = append(pending[:i], pending[i+1:]...)
+	}
+	return order
+}
+
+func TestPCTWithoutChangePointsDeliversByPriority(t *testing.T) {
+	p := NewPCT(1, 1, 10, UnitNode)
+	if len(p.ChangePoints()) != 0 {
+		t.Fatalf("depth 1 must have no change points: %v", p.ChangePoints())
+	}
+	order := drain(p, pendingFrom("a", "b", "a", "b", "a"))
+	if order != "aaabb" && order != "bbaaa" {
+		t.Errorf("messages of a unit were not delivered together: %v", order)
+	}
+}
+
+func TestPCTChangePointDemotesUnit(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		p := NewPCT(seed, 2, 3, UnitChannel)
+		points := p.ChangePoints()
+		if len(points) != 1 || points[0] < 1 || points[0] >= 3 {
+			t.Fatalf("unexpected change points: %v", points)
+		}
+		order := drain(p, pendingFrom("a", "a", "a", "b", "b", "b"))
+		// The unit delivered first is interrupted at the change point.
+		first := order[:1]
+		for i := 0; i < points[0]; i++ {
+			if order[i:i+1] != first {
+				t.Fatalf("seed %v: unit changed before change point %v: %v", seed, points[0], order)
+			}
+		}
+		if order[points[0]:points[0]+1] == first {
+			t.Errorf("seed %v: unit was not demoted at change point %v: %v", seed, points[0], order)
+		}
+	}
+}
+
+func TestPCTIsReproducible(t *testing.T) {
+	first := drain(NewPCT(5, 3, 6, UnitNode), pendingFrom("a", "b", "c", "a", "b", "c"))
+	second := drain(NewPCT(5, 3, 6, UnitNode), pendingFrom("a", "b", "c", "a", "b", "c"))
+	if first != second {
+		t.Errorf("same seed delivered messages in different orders: %v, %v", first, second)
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	for unit, name := range unitNames {
+		if parsed, err := ParseUnit(name); err != nil || parsed != unit {
+			t.Errorf("cannot parse %v: %v, %v", name, parsed, err)
+		}
+	}
+	if _, err := ParseUnit("thread"); err == nil {
+		t.Error("unknown unit was parsed")
+	}
+}
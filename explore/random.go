@@ -0,0 +1,23 @@
+package explore
+
+import (
+	"hse-dss-efimov/network"
+	"math/rand"
+)
+
+// Picks pending messages uniformly at random.
+type Random struct {
+	rng *rand.Rand
+}
+
+func NewRandom(seed int64) *Random {
+	return &Random{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (r *Random) Pick(pending []network.MessageI) int {
+	return r.rng.Intn(len(pending))
+}
+
+func (r *Random) String() string {
+	return "random"
+}
@@ -0,0 +1,273 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hse-dss-efimov/network"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Version of the journal format; bumped on incompatible changes.
+const Version = 1
+
+type Kind string
+
+const (
+	// First record of every journal, carrying its version.
+	KindHeader     Kind = "header"
+	KindReceive    Kind = "receive"
+	KindModify     Kind = "modify"
+	KindDecide     Kind = "decide"
+	KindSend       Kind = "send"
+	KindConnect    Kind = "connect"
+	KindDisconnect Kind = "disconnect"
+	// Node process started or exited under supervision.
+	KindStart Kind = "start"
+	KindExit  Kind = "exit"
+	// Fault applied to a node, such as a crash.
+	KindFault Kind = "fault"
+)
+
+// Journal record. Message fields are set for message events, connection fields
+// for connection events, node fields for node events.
+type Record struct {
+	Kind    Kind `json:"kind"`
+	Version int  `json:"version,omitempty"`
+	// Wall clock time of the event.
+	Wall time.Time `json:"wall"`
+	// Monotonic time of the event in nanoseconds since the journal was created.
+	Mono    int64  `json:"mono"`
+	Channel string `json:"channel,omitempty"`
+
+	Seqnum   uint64 `json:"seqnum,omitempty"`
+	Origin   uint64 `json:"origin,omitempty"`
+	Crc64    uint64 `json:"crc64,omitempty"`
+	Src      string `json:"src,omitempty"`
+	Dst      string `json:"dst,omitempty"`
+	Payload  []byte `json:"payload,omitempty"`
+	Tampered bool   `json:"tampered,omitempty"`
+	Injected bool   `json:"injected,omitempty"`
+	// Number of deliveries for decisions; zero stands for rejection.
+	Copies int `json:"copies,omitempty"`
+
+	Inbound bool   `json:"inbound,omitempty"`
+	Local   string `json:"local,omitempty"`
+	Remote  string `json:"remote,omitempty"`
+
+	Node   string `json:"node,omitempty"`
+	Pid    int    `json:"pid,omitempty"`
+	Status string `json:"status,omitempty"`
+	Fault  string `json:"fault,omitempty"`
+
+	// Random seed and names of the channels of the run, set in the header.
+	Seed     int64    `json:"seed,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+}
+
+var unsupportedVersionError = errors.New("unsupported journal version")
+
+// Appends records to the journal file, one JSON object per line. Implements
+// network.Observer, network.ConnObserver, supervisor.Observer and
+// nemesis.Observer; safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex // protects everything below
+	file    *os.File
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	started time.Time
+	err     error
+}
+
+// Creates journal file at path, truncating existing one. Header records
+// the random seed and the channels of the run.
+func Create(path string, seed int64, channels []string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(file)
+	w := &Writer{file: file, buf: buf, enc: json.NewEncoder(buf), started: time.Now()}
+	w.write(Record{Kind: KindHeader, Version: Version, Seed: seed, Channels: channels})
+	if w.err != nil {
+		file.Close()
+		return nil, w.err
+	}
+	return w, nil
+}
+
+func (w *Writer) write(r Record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return
+	}
+	now := time.Now()
+	r.Wall = now
+	r.Mono = int64(now.Sub(w.started))
+	if w.err = w.enc.Encode(r); w.err == nil {
+		// Records are flushed one by one, so that the journal survives a crash.
+		w.err = w.buf.Flush()
+	}
+}
+
+func messageRecord(kind Kind, msg network.MessageI) Record {
+	return Record{
+		Kind:     kind,
+		Channel:  msg.GetChannel(),
+		Seqnum:   msg.GetSeqNum(),
+		Origin:   msg.GetOrigin(),
+		Crc64:    msg.GetCrc64(),
+		Src:      msg.GetSrc(),
+		Dst:      msg.GetDst(),
+		Payload:  msg.GetPayload(),
+		Tampered: msg.IsTampered(),
+		Injected: msg.IsInjected(),
+	}
+}
+
+func (w *Writer) Received(msg network.MessageI) {
+	w.write(messageRecord(KindReceive, msg))
+}
+
+func (w *Writer) Modified(msg network.MessageI) {
+	w.write(messageRecord(KindModify, msg))
+}
+
+func (w *Writer) Decided(msg network.MessageI, copies int) {
+	r := messageRecord(KindDecide, msg)
+	r.Payload = nil
+	r.Copies = copies
+	w.write(r)
+}
+
+func (w *Writer) Sent(msg network.MessageI) {
+	r := messageRecord(KindSend, msg)
+	r.Payload = nil
+	w.write(r)
+}
+
+func (w *Writer) Connected(channel string, inbound bool, local string, remote string) {
+	w.write(Record{Kind: KindConnect, Channel: channel, Inbound: inbound, Local: local, Remote: remote})
+}
+
+func (w *Writer) Disconnected(channel string, inbound bool, local string, remote string) {
+	w.write(Record{Kind: KindDisconnect, Channel: channel, Inbound: inbound, Local: local, Remote: remote})
+}
+
+func (w *Writer) NodeStarted(node string, pid int) {
+	w.write(Record{Kind: KindStart, Node: node, Pid: pid})
+}
+
+func (w *Writer) NodeExited(node string, pid int, status string) {
+	w.write(Record{Kind: KindExit, Node: node, Pid: pid, Status: status})
+}
+
+func (w *Writer) NodeFault(node string, fault string) {
+	w.write(Record{Kind: KindFault, Node: node, Fault: fault})
+}
+
+// Returns the first error encountered while writing, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.buf.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Reads records of a journal.
+type Reader struct {
+	dec    *json.Decoder
+	Header Record
+}
+
+// Reads journal header from r and checks its version.
+func NewReader(r io.Reader) (*Reader, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header Record
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("cannot read journal header: %v", err)
+	}
+	if header.Kind != KindHeader {
+		return nil, fmt.Errorf("journal must start with a header, got %q", header.Kind)
+	}
+	if header.Version != Version {
+		return nil, fmt.Errorf("%v: %d", unsupportedVersionError, header.Version)
+	}
+	return &Reader{dec: dec, Header: header}, nil
+}
+
+// Returns the next record; io.EOF marks the end of the journal.
+func (r *Reader) Next() (Record, error) {
+	var record Record
+	err := r.dec.Decode(&record)
+	return record, err
+}
+
+// Reads header and all other records of the journal file at path.
+func ReadFile(path string) (Record, []Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Record{}, nil, err
+	}
+	defer file.Close()
+
+	reader, err := NewReader(file)
+	if err != nil {
+		return Record{}, nil, err
+	}
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header, records, nil
+		}
+		if err != nil {
+			// Journal of a crashed process may end with a partial record.
+			if err == io.ErrUnexpectedEOF {
+				return reader.Header, records, nil
+			}
+			return reader.Header, records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// Writes header and records to the journal file at path as they are, truncating existing one.
+func WriteFile(path string, header Record, records []Record) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(file)
+	enc := json.NewEncoder(buf)
+	for _, record := range append([]Record{header}, records...) {
+		if err := enc.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
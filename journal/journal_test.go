@@ -0,0 +1,122 @@
+package journal
+
+import (
+	"hse-dss-efimov/network"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	w, err := Create(path, 42, []string{"1-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &network.Message{Seqnum: 1, Crc64: 7, Channel: "1-2", Src: "1", Dst: "2", Payload: []byte("x")}
+	w.Connected("1-2", true, "a", "b")
+	w.Received(msg)
+	w.Decided(msg, 2)
+	w.Sent(msg)
+	w.Disconnected("1-2", true, "a", "b")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header, records, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Seed != 42 || len(header.Channels) != 1 || header.Channels[0] != "1-2" {
+		t.Errorf("unexpected header: %+v", header)
+	}
+	kinds := []Kind{KindConnect, KindReceive, KindDecide, KindSend, KindDisconnect}
+	if len(records) != len(kinds) {
+		t.Fatalf("unexpected number of records: %v", len(records))
+	}
+	for i, r := range records {
+		if r.Kind != kinds[i] || r.Channel != "1-2" {
+			t.Errorf("unexpected record %v: %+v", i, r)
+		}
+		if i > 0 && r.Mono < records[i-1].Mono {
+			t.Errorf("monotonic time goes backwards at %v", i)
+		}
+	}
+	if r := records[1]; r.Seqnum != 1 || r.Crc64 != 7 || string(r.Payload) != "x" {
+		t.Errorf("unexpected receive record: %+v", r)
+	}
+	if r := records[2]; r.Copies != 2 {
+		t.Errorf("unexpected decision record: %+v", r)
+	}
+
+	copyPath := filepath.Join(t.TempDir(), "copy")
+	if err := WriteFile(copyPath, header, records[1:3]); err != nil {
+		t.Fatal(err)
+	}
+	copyHeader, copied, err := ReadFile(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copyHeader.Seed != header.Seed || len(copied) != 2 || copied[0].Kind != KindReceive ||
+		!copied[1].Wall.Equal(records[2].Wall) {
+		t.Errorf("unexpected copy: %+v, %+v", copyHeader, copied)
+	}
+}
+
+func TestJournalNodeEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	w, err := Create(path, 42, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.NodeFault("a", "crash")
+	w.NodeExited("a", 10, "signal: killed")
+	w.NodeStarted("a", 11)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, records, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Record{
+		{Kind: KindFault, Node: "a", Fault: "crash"},
+		{Kind: KindExit, Node: "a", Pid: 10, Status: "signal: killed"},
+		{Kind: KindStart, Node: "a", Pid: 11},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("unexpected number of records: %v", len(records))
+	}
+	for i, r := range records {
+		e := expected[i]
+		if r.Kind != e.Kind || r.Node != e.Node || r.Pid != e.Pid || r.Status != e.Status || r.Fault != e.Fault {
+			t.Errorf("unexpected record %v: %+v", i, r)
+		}
+	}
+}
+
+func TestJournalTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	w, err := Create(path, 42, []string{"1-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Connected("1-2", false, "a", "b")
+	w.Close()
+
+	// Journal of a crashed process ends with a partial record.
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append(data, []byte(`{"kind":"rece`)...), 0644)
+	_, records, err := ReadFile(path)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("unexpected result: %v records, %v", len(records), err)
+	}
+}
+
+func TestJournalVersion(t *testing.T) {
+	if _, err := NewReader(strings.NewReader(`{"kind":"header","version":999}`)); err == nil {
+		t.Fatalf("unsupported version must be rejected")
+	}
+}
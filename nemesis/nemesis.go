@@ -0,0 +1,344 @@
+package nemesis
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fate of messages to and from a node while it is down.
+type PendingPolicy int
+
+const (
+	// Messages are rejected, as if lost along with the node.
+	PendingDrop PendingPolicy = iota
+	// Messages are held until the node is restarted. Then the ones it sent are
+	// accepted, while the ones sent to it are rejected: connections they were
+	// read for died with the node, so hold only delays traffic of surviving peers.
+	PendingHold
+)
+
+var pendingPolicyNames = map[PendingPolicy]string{
+	PendingDrop: "drop",
+	PendingHold: "hold",
+}
+
+func (p PendingPolicy) String() string {
+	if name, ok := pendingPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("PendingPolicy(%d)", int(p))
+}
+
+func ParsePendingPolicy(s string) (PendingPolicy, error) {
+	if s == "" {
+		return PendingDrop, nil
+	}
+	for policy, name := range pendingPolicyNames {
+		if name == s {
+			return policy, nil
+		}
+	}
+	return PendingDrop, fmt.Errorf("unknown pending policy %q", s)
+}
+
+const (
+	// Node is killed with SIGKILL.
+	FaultCrash = "crash"
+	// Node is stopped with SIGTERM.
+	FaultStop = "stop"
+	// Node is started again, or stopped and started if it is running.
+	FaultRestart = "restart"
+	// Node is frozen with SIGSTOP; its channels keep accepting traffic from peers.
+	FaultPause = "pause"
+	// Paused node is resumed with SIGCONT.
+	FaultResume = "resume"
+)
+
+// Node processes faults are applied to; implemented by supervisor.Supervisor.
+type Nodes interface {
+	Kill(name string) error
+	Stop(name string) error
+	Restart(name string) error
+	Pause(name string) error
+	Resume(name string) error
+}
+
+// Observer of faults applied to nodes.
+type Observer interface {
+	NodeFault(node string, fault string)
+}
+
+// Fault applied at a given time since Schedule is called.
+type Scheduled struct {
+	After time.Duration `mapstructure:"after"`
+	Fault string        `mapstructure:"fault"`
+	Node  string        `mapstructure:"node"`
+	// Time after which a crashed or stopped node is restarted, or a paused one
+	// resumed; zero leaves it so.
+	Duration time.Duration `mapstructure:"duration"`
+}
+
+// Crashes, stops, restarts and pauses nodes on demand or by schedule. While a
+// node is down, messages to and from it are dropped or held by the pending
+// policy; used as Interceptor of every channel for that. Paused node is not
+// down: messages to it are decided on as usual and pile up in its sockets.
+type Nemesis struct {
+	logger   zap.Logger
+	nodes    Nodes
+	ports    map[string]map[int]bool
+	channels []network.Channel
+	policy   PendingPolicy
+
+	mu       sync.Mutex // protects everything below
+	observer Observer
+	down     map[string]bool
+	held     map[string][]network.MessageI
+	timers   []*time.Timer
+	closed   bool
+}
+
+// Creates nemesis for nodes identified by their ports, such as the ones returned
+// by supervisor.Topology.Ports.
+func NewNemesis(nodes Nodes, ports map[string][]int, channels []network.Channel, policy PendingPolicy,
+	logger zap.Logger) *Nemesis {
+	n := &Nemesis{
+		logger:   logger,
+		nodes:    nodes,
+		ports:    make(map[string]map[int]bool, len(ports)),
+		channels: channels,
+		policy:   policy,
+		down:     make(map[string]bool),
+		held:     make(map[string][]network.MessageI),
+	}
+	for node, nodePorts := range ports {
+		n.ports[node] = make(map[int]bool, len(nodePorts))
+		for _, port := range nodePorts {
+			n.ports[node][port] = true
+		}
+	}
+	return n
+}
+
+// Sets observer notified about every fault applied; nil removes it.
+func (n *Nemesis) SetObserver(observer Observer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.observer = observer
+}
+
+// Applies fault to the node. Crashed or stopped node is restarted, and paused
+// node is resumed, after the given duration unless it is zero. Fault is recorded
+// before it is applied, so that it precedes the exit of the node in the journal;
+// pause and resume do not end the node and are recorded once they succeed.
+func (n *Nemesis) Apply(fault string, node string, d time.Duration) error {
+	if err := n.check(fault, node); err != nil {
+		return err
+	}
+	switch fault {
+	case FaultCrash:
+		return n.takeDown(fault, node, n.nodes.Kill, d)
+	case FaultStop:
+		return n.takeDown(fault, node, n.nodes.Stop, d)
+	case FaultPause:
+		return n.pause(node, d)
+	case FaultResume:
+		if err := n.nodes.Resume(node); err != nil {
+			return err
+		}
+		n.record(fault, node)
+		return nil
+	default:
+		return n.restart(node)
+	}
+}
+
+func (n *Nemesis) check(fault string, node string) error {
+	if _, ok := n.ports[node]; !ok {
+		return fmt.Errorf("unknown node %q", node)
+	}
+	switch fault {
+	case FaultCrash, FaultStop, FaultRestart, FaultPause, FaultResume:
+		return nil
+	default:
+		return fmt.Errorf("unknown fault %q", fault)
+	}
+}
+
+func (n *Nemesis) record(fault string, node string) {
+	n.mu.Lock()
+	observer := n.observer
+	n.mu.Unlock()
+
+	n.logger.Info("applying fault", zap.String("fault", fault), zap.String("node", node))
+	if observer != nil {
+		observer.NodeFault(node, fault)
+	}
+}
+
+// Takes the node down, dropping messages pending manual decision under drop policy.
+func (n *Nemesis) takeDown(fault string, node string, stop func(name string) error, d time.Duration) error {
+	n.mu.Lock()
+	wasDown := n.down[node]
+	// Messages arriving while the node goes down are intercepted already.
+	n.down[node] = true
+	n.mu.Unlock()
+
+	n.record(fault, node)
+	if err := stop(node); err != nil {
+		n.mu.Lock()
+		n.down[node] = wasDown
+		n.mu.Unlock()
+		return err
+	}
+
+	if n.policy == PendingDrop {
+		dropped := 0
+		for _, channel := range n.channels {
+			for _, msg := range channel.Pending() {
+				if n.touches(node, msg.GetSrc(), msg.GetDst()) {
+					msg.Reject()
+					dropped++
+				}
+			}
+		}
+		n.logger.Debug("dropped pending messages of node", zap.String("node", node), zap.Int("dropped", dropped))
+	}
+	if d > 0 {
+		n.after(d, func() {
+			if err := n.Apply(FaultRestart, node, 0); err != nil {
+				n.logger.Error("cannot restart node", zap.String("node", node), zap.Error(err))
+			}
+		})
+	}
+	return nil
+}
+
+// Restarts the node, then decides on messages held while it was down.
+func (n *Nemesis) restart(node string) error {
+	n.record(FaultRestart, node)
+	if err := n.nodes.Restart(node); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	delete(n.down, node)
+	held := n.held[node]
+	delete(n.held, node)
+	n.mu.Unlock()
+
+	// Decisions are made without the lock, since they lock semichannels.
+	sort.Slice(held, func(i, j int) bool { return held[i].GetSeqNum() < held[j].GetSeqNum() })
+	released := 0
+	for _, msg := range held {
+		if n.owns(node, msg.GetDst()) {
+			msg.Reject()
+			continue
+		}
+		msg.Accept()
+		released++
+	}
+	n.logger.Debug("released held messages of node", zap.String("node", node),
+		zap.Int("released", released), zap.Int("dropped", len(held)-released))
+	return nil
+}
+
+// Freezes the node, resuming it after the given duration unless it is zero.
+func (n *Nemesis) pause(node string, d time.Duration) error {
+	if err := n.nodes.Pause(node); err != nil {
+		return err
+	}
+	n.record(FaultPause, node)
+	if d > 0 {
+		n.after(d, func() {
+			if err := n.Apply(FaultResume, node, 0); err != nil {
+				n.logger.Error("cannot resume node", zap.String("node", node), zap.Error(err))
+			}
+		})
+	}
+	return nil
+}
+
+// Applies faults by schedule, counting time from now. Returns error without
+// scheduling anything if any fault or node is unknown.
+func (n *Nemesis) Schedule(faults []Scheduled) error {
+	for _, f := range faults {
+		if err := n.check(f.Fault, f.Node); err != nil {
+			return err
+		}
+	}
+	for _, f := range faults {
+		f := f
+		n.after(f.After, func() {
+			if err := n.Apply(f.Fault, f.Node, f.Duration); err != nil {
+				n.logger.Error("cannot apply scheduled fault",
+					zap.String("fault", f.Fault), zap.String("node", f.Node), zap.Error(err))
+			}
+		})
+	}
+	return nil
+}
+
+func (n *Nemesis) after(d time.Duration, f func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+	n.timers = append(n.timers, time.AfterFunc(d, f))
+}
+
+// Cancels faults not applied yet.
+func (n *Nemesis) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.closed = true
+	for _, timer := range n.timers {
+		timer.Stop()
+	}
+	n.timers = nil
+}
+
+// Returns true if the Message is sent from or to a port of the node.
+func (n *Nemesis) touches(node string, src string, dst string) bool {
+	return n.owns(node, src) || n.owns(node, dst)
+}
+
+func (n *Nemesis) owns(node string, port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && n.ports[node][p]
+}
+
+func (n *Nemesis) Intercept(msg *network.Message) bool {
+	n.mu.Lock()
+	var node string
+	for candidate := range n.down {
+		if n.touches(candidate, msg.Src, msg.Dst) {
+			node = candidate
+			break
+		}
+	}
+	if node != "" && n.policy == PendingHold {
+		n.held[node] = append(n.held[node], msg)
+	}
+	n.mu.Unlock()
+
+	if node == "" {
+		return false
+	}
+	fields := []zap.Field{zap.Uint64("seqnum", msg.Seqnum), zap.String("channel", msg.Channel), zap.String("node", node)}
+	if n.policy == PendingHold {
+		n.logger.Debug("Message held while node is down", fields...)
+	} else {
+		n.logger.Debug("Message dropped while node is down", fields...)
+		msg.Reject()
+	}
+	return true
+}
@@ -0,0 +1,451 @@
+package nemesis
+
+import (
+	"encoding/binary"
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeNodes struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeNodes) call(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, name)
+	return nil
+}
+
+func (f *fakeNodes) Kill(name string) error    { return f.call("kill " + name) }
+func (f *fakeNodes) Stop(name string) error    { return f.call("stop " + name) }
+func (f *fakeNodes) Restart(name string) error { return f.call("restart " + name) }
+func (f *fakeNodes) Pause(name string) error   { return f.call("pause " + name) }
+func (f *fakeNodes) Resume(name string) error  { return f.call("resume " + name) }
+
+func (f *fakeNodes) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+type fakeObserver struct {
+	mu     sync.Mutex
+	faults []string
+}
+
+func (o *fakeObserver) NodeFault(node string, fault string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.faults = append(o.faults, fault+" "+node)
+}
+
+func (o *fakeObserver) Faults() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]string(nil), o.faults...)
+}
+
+type fakeChannel struct {
+	network.Channel
+	pending []network.MessageI
+}
+
+func (c *fakeChannel) Pending() []network.MessageI {
+	return c.pending
+}
+
+// Decisions on messages by seqnum: number of copies, zero for rejection.
+type decisions map[uint64]int
+
+func (d decisions) message(seqnum uint64, src string, dst string) *network.Message {
+	msg := &network.Message{Seqnum: seqnum, Src: src, Dst: dst}
+	msg.DecideFn = func(copies int) {
+		d[seqnum] = copies
+	}
+	return msg
+}
+
+// Node a listens on 7002 and reaches b through 7001; b listens on 7102 and reaches a through 7101.
+var ports = map[string][]int{"a": {7002, 7001}, "b": {7102, 7101}}
+
+func TestNemesisDropsMessagesOfCrashedNode(t *testing.T) {
+	decided := decisions{}
+	channel := &fakeChannel{pending: []network.MessageI{
+		decided.message(1, "7001", "7102"),
+		decided.message(2, "7101", "7999"),
+	}}
+	nodes := &fakeNodes{}
+	observer := &fakeObserver{}
+	n := NewNemesis(nodes, ports, []network.Channel{channel}, PendingDrop, *zap.NewNop())
+	n.SetObserver(observer)
+
+	if err := n.Apply(FaultCrash, "a", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decided, decisions{1: 0}) {
+		t.Errorf("pending Message from crashed node must be dropped, got %v", decided)
+	}
+	if !n.Intercept(decided.message(3, "7101", "7002")) {
+		t.Errorf("Message to crashed node must be intercepted")
+	}
+	if n.Intercept(decided.message(4, "7101", "7999")) {
+		t.Errorf("Message between other nodes must not be intercepted")
+	}
+	if copies, ok := decided[3]; !ok || copies != 0 {
+		t.Errorf("Message to crashed node must be dropped, got %v", decided)
+	}
+
+	if err := n.Apply(FaultRestart, "a", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n.Intercept(decided.message(5, "7101", "7002")) {
+		t.Errorf("Message to restarted node must not be intercepted")
+	}
+	if calls := nodes.Calls(); !reflect.DeepEqual(calls, []string{"kill a", "restart a"}) {
+		t.Errorf("unexpected calls %v", calls)
+	}
+	if faults := observer.Faults(); !reflect.DeepEqual(faults, []string{"crash a", "restart a"}) {
+		t.Errorf("unexpected faults recorded %v", faults)
+	}
+}
+
+func TestNemesisHoldsMessagesUntilRestart(t *testing.T) {
+	decided := decisions{}
+	channel := &fakeChannel{pending: []network.MessageI{decided.message(1, "7001", "7102")}}
+	n := NewNemesis(&fakeNodes{}, ports, []network.Channel{channel}, PendingHold, *zap.NewNop())
+
+	if err := n.Apply(FaultStop, "b", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var accepted []uint64
+	for _, seqnum := range []uint64{3, 2} {
+		msg := decided.message(seqnum, "7102", "7001")
+		msg.DecideFn = func(copies int) {
+			accepted = append(accepted, msg.Seqnum)
+		}
+		if !n.Intercept(msg) {
+			t.Errorf("Message from stopped node must be intercepted")
+		}
+	}
+	if !n.Intercept(decided.message(4, "7001", "7102")) {
+		t.Errorf("Message to stopped node must be intercepted")
+	}
+	if len(decided) != 0 || len(accepted) != 0 {
+		t.Errorf("messages must be held, got %v, %v", decided, accepted)
+	}
+
+	if err := n.Apply(FaultRestart, "b", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(accepted, []uint64{2, 3}) {
+		t.Errorf("held messages from restarted node must be accepted in order, got %v", accepted)
+	}
+	// Connections to the node held messages were read for are gone.
+	if !reflect.DeepEqual(decided, decisions{4: 0}) {
+		t.Errorf("held Message to restarted node must be dropped, pending one left, got %v", decided)
+	}
+}
+
+func TestNemesisSchedule(t *testing.T) {
+	nodes := &fakeNodes{}
+	n := NewNemesis(nodes, ports, nil, PendingDrop, *zap.NewNop())
+	err := n.Schedule([]Scheduled{
+		{After: 10 * time.Millisecond, Fault: FaultCrash, Node: "a", Duration: 20 * time.Millisecond},
+		{After: time.Hour, Fault: FaultStop, Node: "b"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"kill a", "restart a"}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(nodes.Calls(), expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	n.Close()
+	if calls := nodes.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestNemesisPauseKeepsTraffic(t *testing.T) {
+	nodes := &fakeNodes{}
+	observer := &fakeObserver{}
+	n := NewNemesis(nodes, ports, nil, PendingHold, *zap.NewNop())
+	n.SetObserver(observer)
+
+	if err := n.Apply(FaultPause, "a", 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decided := decisions{}
+	if n.Intercept(decided.message(1, "7101", "7002")) {
+		t.Errorf("Message to paused node must not be intercepted")
+	}
+
+	expected := []string{"pause a", "resume a"}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(nodes.Calls(), expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if calls := nodes.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, calls)
+	}
+	for len(observer.Faults()) < len(expected) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	n.Close()
+	if faults := observer.Faults(); !reflect.DeepEqual(faults, expected) {
+		t.Errorf("unexpected faults recorded %v", faults)
+	}
+}
+
+func TestNemesisUnknown(t *testing.T) {
+	n := NewNemesis(&fakeNodes{}, ports, nil, PendingDrop, *zap.NewNop())
+	if err := n.Apply(FaultCrash, "c", 0); err == nil {
+		t.Errorf("expected error for unknown node")
+	}
+	if err := n.Apply("explode", "a", 0); err == nil {
+		t.Errorf("expected error for unknown fault")
+	}
+	if err := n.Schedule([]Scheduled{{Fault: FaultCrash, Node: "a"}, {Fault: FaultStop, Node: "c"}}); err == nil {
+		t.Errorf("expected error scheduling fault of unknown node")
+	}
+}
+
+// Node serving frames on its port: replies to every frame with "re:" and the frame.
+type echoNode struct {
+	port int
+
+	mu       sync.Mutex // protects everything below
+	listener net.Listener
+	conns    []net.Conn
+	served   [][]byte
+}
+
+func (e *echoNode) start() error {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(e.port))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.listener = l
+	e.mu.Unlock()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			e.mu.Lock()
+			e.conns = append(e.conns, conn)
+			e.mu.Unlock()
+			go e.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func (e *echoNode) serve(conn net.Conn) {
+	var dec network.Decoder
+	dec.Reset()
+	for {
+		payload, err := dec.ReadFrom(conn)
+		if payload != nil {
+			e.mu.Lock()
+			e.served = append(e.served, payload)
+			e.mu.Unlock()
+			if _, err := conn.Write(frame(append([]byte("re:"), payload...))); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (e *echoNode) Kill(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.listener.Close()
+	for _, conn := range e.conns {
+		conn.Close()
+	}
+	e.conns = nil
+	return nil
+}
+
+func (e *echoNode) Stop(name string) error    { return e.Kill(name) }
+func (e *echoNode) Restart(name string) error { return e.start() }
+func (e *echoNode) Pause(name string) error   { return nil }
+func (e *echoNode) Resume(name string) error  { return nil }
+
+func (e *echoNode) Served() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([][]byte(nil), e.served...)
+}
+
+func frame(payload []byte) []byte {
+	out := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(len(payload)))
+	copy(out[4:], payload)
+	return out
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Intercept(msg *network.Message) bool {
+	msg.Accept()
+	return true
+}
+
+// Decisions on messages by payload.
+type payloadObserver struct {
+	mu       sync.Mutex
+	received map[string]bool
+	decided  map[string]int
+}
+
+func (o *payloadObserver) Received(msg network.MessageI) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.received[string(msg.GetPayload())] = true
+}
+
+func (o *payloadObserver) Decided(msg network.MessageI, copies int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.decided[string(msg.GetPayload())] = copies
+}
+
+func (o *payloadObserver) Modified(msg network.MessageI) {}
+func (o *payloadObserver) Sent(msg network.MessageI)     {}
+
+func (o *payloadObserver) state(payload string) (bool, int, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	copies, decided := o.decided[payload]
+	return o.received[payload], copies, decided
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Connects to the port, waiting for the channel to start listening on it.
+func dial(t *testing.T, port int) net.Conn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cannot connect to channel: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sends request through the channel and waits for the reply.
+func request(t *testing.T, srcPort int, payload string) {
+	conn := dial(t, srcPort)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(frame([]byte(payload))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var dec network.Decoder
+	dec.Reset()
+	reply, err := dec.ReadFrom(conn)
+	if err != nil || string(reply) != "re:"+payload {
+		t.Fatalf("unexpected reply %q, %v", reply, err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNemesisHoldDropsMessagesToRestartedNode(t *testing.T) {
+	node := &echoNode{port: freePort(t)}
+	if err := node.start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer node.Kill("a")
+	srcPort := freePort(t)
+
+	counter := uint64(0)
+	channel := network.NewChannel("test", srcPort, node.port, &counter, *zap.NewNop(), make(chan network.Message, 10))
+	defer channel.Close()
+	observer := &payloadObserver{received: make(map[string]bool), decided: make(map[string]int)}
+	channel.SetObserver(observer)
+	n := NewNemesis(node, map[string][]int{"a": {node.port}}, []network.Channel{channel}, PendingHold, *zap.NewNop())
+	channel.SetInterceptor(network.InterceptorChain{n, acceptAll{}})
+
+	conn := dial(t, srcPort)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(frame([]byte("first")))
+	var dec network.Decoder
+	dec.Reset()
+	if reply, err := dec.ReadFrom(conn); err != nil || string(reply) != "re:first" {
+		t.Fatalf("unexpected reply %q, %v", reply, err)
+	}
+
+	if err := n.Apply(FaultCrash, "a", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Connection the request is read for goes away with the node.
+	conn.Write(frame([]byte("lost")))
+	waitFor(t, "held Message", func() bool {
+		received, _, _ := observer.state("lost")
+		return received
+	})
+	if _, _, decided := observer.state("lost"); decided {
+		t.Fatalf("Message to crashed node must be held")
+	}
+
+	if err := n.Apply(FaultRestart, "a", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, copies, decided := observer.state("lost"); !decided || copies != 0 {
+		t.Errorf("held Message to restarted node must be dropped, got %v copies", copies)
+	}
+
+	// Restarted node serves new connections.
+	request(t, srcPort, "second")
+	if served := node.Served(); len(served) != 2 || string(served[1]) != "second" {
+		t.Errorf("unexpected requests served: %q", served)
+	}
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"io"
@@ -18,18 +19,81 @@ type Channel interface {
 	GetSrcPort() int
 	// Returns destination (outbound) port.
 	GetDstPort() int
+	// Sets interceptor deciding on messages before they are handed out; nil restores manual mode.
+	SetInterceptor(interceptor Interceptor)
+	// Configures hold-back buffer for accepted messages; previously held messages are released.
+	SetReorder(config Reorder)
+	// Releases held messages in the given order; returns number of released messages.
+	Release(order []uint64) int
+	// Forges Message with the given payload and enqueues it for delivery to destination port
+	// through the connection of the latest client; fails unless some client is connected.
+	Inject(payload []byte) (MessageI, error)
+	// Aborts established connections on both sides with RST; clients reconnect afterwards.
+	ResetConnections()
+	// Shuts down writing half of inbound connections (towards source) or outbound ones (towards destination).
+	HalfCloseConnections(inbound bool)
+	// Resets inbound connections accepted within the given period.
+	RefuseConnections(d time.Duration)
+	// Abandons frames partially written to destination, leaving them truncated;
+	// returns the number of such frames, zero meaning nothing is dropped.
+	DropPartialFrame() int
+	// Limits bandwidth and adds latency to the traffic written by the channel.
+	SetShaping(config Shaping)
+	// Bounds the number of messages awaiting manual decision; zero capacity lifts the bound.
+	SetPendingLimit(capacity int, policy OverflowPolicy)
+	// Returns messages awaiting manual decision in arrival order.
+	Pending() []MessageI
+	// Sets observer notified about every Message passing through the channel; nil removes it.
+	SetObserver(observer Observer)
 	// Closes the channel, aborting all in-flight messages.
 	Close()
 }
 
-// Represents unidirectional flow within a full-duplex channel.
+// Decides on intercepted messages instead of a human.
+type Interceptor interface {
+	// Returns true if Message was taken care of and must not be handed out for manual decision.
+	Intercept(msg *Message) bool
+}
+
+// Interceptors tried in order until one of them takes care of Message.
+type InterceptorChain []Interceptor
+
+func (chain InterceptorChain) Intercept(msg *Message) bool {
+	for _, interceptor := range chain {
+		if interceptor.Intercept(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Represents unidirectional flow of a link. Inbound flow is read from the connection
+// accepted on source port and written to the one established towards destination
+// port; outbound flow goes the opposite way.
 type semichannel struct {
-	mu        sync.RWMutex // protects read queue
-	dec       Decoder
-	enc       encoder
+	channel   string
+	mu        sync.RWMutex // protects read queue and hold buffer
 	readQueue []MessageI
-	writeMsg  MessageI
-	writeCh   chan MessageI
+	hold      *holdBuffer
+	timers    *timerSet
+	pending   *pendingQueue
+	observer  *observerRef
+	writes    writeQueue
+	partial   int32 // set while a frame is partially written
+	dropFrame int32 // set to abandon the frame partially written
+	readDone  bool  // set once the source half-closed; protected by mu
+
+	// Writer consults the shaper before every frame, so it has a lock of its own.
+	shaperMu sync.Mutex // protects shaper
+	shaper   *shaper
+}
+
+// Pair of connections proxying a single client: the one accepted on source port
+// and the one established for it towards destination port. Every link has flows
+// of its own, so responses return to the client that sent requests.
+type link struct {
+	inbound  *semichannel
+	outbound *semichannel
 }
 
 // Represents bidirectional channel.
@@ -40,13 +104,43 @@ type channel struct {
 	counter *uint64
 	logger  zap.Logger
 
+	mu          sync.RWMutex // protects interceptor
+	interceptor Interceptor
+
+	connMu      sync.Mutex // protects conns, links, refuseUntil, reorder and shaping
+	conns       map[*net.TCPConn]*connState
+	links       []*link // in order of establishment
+	refuseUntil time.Time
+	// Applied to flows of every link, including the ones established later.
+	reorder Reorder
+	shaping Shaping
+
 	closeCh chan struct{}
 	closeWg sync.WaitGroup
+	timers   *timerSet
+	pending  *pendingQueue
+	observer *observerRef
+}
+
+// Established connection tracked for fault injection.
+type connState struct {
+	inbound      bool
+	abortCh      chan struct{} // closed once connection is torn down
+	abortOnce    sync.Once
+	closeWriteCh chan struct{} // closed once connection is half-closed
+	closeOnce    sync.Once
+}
+
+func (s *connState) abort() {
+	s.abortOnce.Do(func() { close(s.abortCh) })
+}
 
-	inbound  semichannel
-	outbound semichannel
+func (s *connState) closeWrite() {
+	s.closeOnce.Do(func() { close(s.closeWriteCh) })
 }
 
+var noConnectionError = errors.New("no client is connected")
+
 const (
 	backoffTimeout = 10 * time.Second
 	readTimeout    = 1 * time.Second
@@ -68,17 +162,34 @@ func isEOF(err error) bool {
 }
 
 func fieldsFor(msg MessageI) []zapcore.Field {
-	return []zapcore.Field{
+	fields := []zapcore.Field{
 		zap.Uint64("Seqnum", msg.GetSeqNum()),
 		zap.Uint64("Crc64", msg.GetCrc64()),
 		zap.Int("size", msg.GetSize())}
+	if origin := msg.GetOrigin(); origin != 0 {
+		fields = append(fields, zap.Uint64("Origin", origin))
+	}
+	if msg.IsTampered() {
+		fields = append(fields, zap.Bool("tampered", true))
+	}
+	if msg.IsInjected() {
+		fields = append(fields, zap.Bool("injected", true))
+	}
+	return fields
 }
 
-func (sc *semichannel) addMessage(msg MessageI) {
+// Registers Message read from a connection. Sequence number is drawn under the lock,
+// so that read queue stays sorted while several connections feed the flow.
+func (sc *semichannel) receive(payload []byte, src string, dst string, counter *uint64, logger zap.Logger) *Message {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 
+	msg := &Message{Seqnum: atomic.AddUint64(counter, 1), Crc64: computeCrc64(payload), Payload: payload,
+		Channel: sc.channel, Src: src, Dst: dst, timers: sc.timers, logger: &logger}
+	msg.DecideFn = func(copies int) { sc.decideOnMessage(msg, copies, counter, logger) }
+	msg.modifyFn = func(payload []byte) (*Message, error) { return msg, sc.modifyMessage(msg, payload, logger) }
 	sc.readQueue = append(sc.readQueue, msg)
+	return msg
 }
 
 func (sc *semichannel) getMessageIndexBySeqNum(seqnum uint64) int {
@@ -92,111 +203,322 @@ func (sc *semichannel) getMessageIndexBySeqNum(seqnum uint64) int {
 	}
 }
 
-func (sc *semichannel) decideOnMessage(msg MessageI, outcome bool, logger zap.Logger) {
+func (sc *semichannel) decideOnMessage(msg *Message, copies int, counter *uint64, logger zap.Logger) {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
 
 	if i := sc.getMessageIndexBySeqNum(msg.GetSeqNum()); i >= 0 {
 		sc.readQueue = append(sc.readQueue[:i], sc.readQueue[i+1:]...)
-		if outcome {
+		sc.pending.remove(msg.Seqnum)
+		sc.observer.decided(msg, copies)
+		if copies > 0 {
 			logger.Debug("Message accepted", fieldsFor(msg)...)
-			sc.writeCh <- msg
+			sc.enqueue(msg, logger)
+			for ; copies > 1; copies-- {
+				dup := &Message{Seqnum: atomic.AddUint64(counter, 1), Origin: msg.Seqnum,
+					Crc64: msg.Crc64, Payload: msg.Payload, Tampered: msg.Tampered, Injected: msg.Injected,
+					Channel: msg.Channel, Src: msg.Src, Dst: msg.Dst, timers: msg.timers, logger: msg.logger}
+				logger.Debug("Message duplicated", fieldsFor(dup)...)
+				sc.observer.received(dup)
+				sc.observer.decided(dup, 1)
+				sc.enqueue(dup, logger)
+			}
 		} else {
 			logger.Debug("Message rejected", fieldsFor(msg)...)
+			// Writer may be waiting for the flow to drain.
+			sc.writes.wake()
 		}
 	} else {
 		logger.Debug("ignoring duplicate request for Message processing", fieldsFor(msg)...)
 	}
 }
 
+func (sc *semichannel) modifyMessage(msg *Message, payload []byte, logger zap.Logger) error {
+	// Frame could not be written, wedging the flow.
+	if len(payload) > maxPayload {
+		logger.Debug("ignoring request for modification exceeding frame limit",
+			append(fieldsFor(msg), zap.Int("requestedSize", len(payload)))...)
+		return maxPayloadError
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if i := sc.getMessageIndexBySeqNum(msg.GetSeqNum()); i >= 0 {
+		crc := msg.Crc64
+		msg.setPayload(payload)
+		logger.Debug("Message modified", append(fieldsFor(msg), zap.Uint64("originalCrc64", crc))...)
+		sc.observer.modified(msg)
+		return nil
+	}
+	logger.Debug("ignoring request for modification of decided Message", fieldsFor(msg)...)
+	return decidedError
+}
+
+func (sc *semichannel) inject(msg *Message, logger zap.Logger) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	logger.Info("Message injected", fieldsFor(msg)...)
+	sc.observer.received(msg)
+	sc.observer.decided(msg, 1)
+	sc.enqueue(msg, logger)
+}
+
+// Marks the end of the flow: its writer half-closes once everything read is written.
+func (sc *semichannel) finish() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.readDone = true
+	sc.writes.wake()
+}
+
+// Reports whether the flow ended and every Message read is decided and written.
+func (sc *semichannel) drained() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.readDone && len(sc.readQueue) == 0 && (sc.hold == nil || len(sc.hold.held) == 0) && sc.writes.len() == 0
+}
+
+// Must be called with mu held.
+func (sc *semichannel) enqueue(msg MessageI, logger zap.Logger) {
+	if sc.hold == nil {
+		sc.writes.put(msg, time.Now())
+		return
+	}
+	if released := sc.hold.add(msg); released != nil {
+		sc.release(released, logger)
+		return
+	}
+	logger.Debug("Message held back", fieldsFor(msg)...)
+	config := sc.hold.config
+	if sc.hold.cancelTimer == nil && config.Mode != ReorderExplicit && config.Timeout > 0 {
+		hold := sc.hold
+		hold.cancelTimer = sc.timers.after(config.Timeout, func() { sc.flushHeld(hold, logger) })
+	}
+}
+
+// Must be called with mu held.
+func (sc *semichannel) release(msgs []MessageI, logger zap.Logger) {
+	if len(msgs) == 0 {
+		return
+	}
+	logger.Info("releasing held messages",
+		zap.Stringer("mode", sc.hold.config.Mode),
+		zap.Uint64s("order", seqNumsOf(msgs)))
+	now := time.Now()
+	for _, msg := range msgs {
+		sc.writes.put(msg, now)
+	}
+}
+
+func (sc *semichannel) flushHeld(hold *holdBuffer, logger zap.Logger) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.hold == hold {
+		sc.release(hold.flush(), logger)
+	}
+}
+
+func (sc *semichannel) releaseHeld(order []uint64, logger zap.Logger) int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.hold == nil {
+		return 0
+	}
+	msgs := sc.hold.take(order)
+	sc.release(msgs, logger)
+	return len(msgs)
+}
+
+func (sc *semichannel) setReorder(config Reorder, logger zap.Logger) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.hold != nil {
+		sc.release(sc.hold.flush(), logger)
+	}
+	if config.Mode == ReorderNone {
+		sc.hold = nil
+	} else {
+		sc.hold = newHoldBuffer(config)
+	}
+}
+
+func (sc *semichannel) setShaping(config Shaping) {
+	sc.shaperMu.Lock()
+	defer sc.shaperMu.Unlock()
+
+	if config.enabled() {
+		sc.shaper = newShaper(config)
+	} else {
+		sc.shaper = nil
+	}
+}
+
+// Returns delay of the next frame carrying payload of the given size, enqueued at the given time.
+func (sc *semichannel) shapingDelay(size int, enqueued time.Time, now time.Time) time.Duration {
+	sc.shaperMu.Lock()
+	defer sc.shaperMu.Unlock()
+
+	if sc.shaper == nil {
+		return 0
+	}
+	// Frame carries length prefix along with the payload.
+	return sc.shaper.delay(size+4, enqueued, now)
+}
+
+// Reads the flow from the connection; reports nil once the peer half-closes it or
+// the connection is torn down, and the failure otherwise.
 func runConnectionRead(
-	doneCh chan struct{},
+	doneCh chan<- error,
 	closeCh <-chan struct{},
+	abortCh <-chan struct{},
 	counter *uint64,
 	sc *semichannel,
 	conn *net.TCPConn,
 	connLogger zap.Logger,
-	msgChan chan Message,
+	intercept func(msg *Message) bool,
+	handOut func(msg *Message, cancel <-chan struct{}),
 	src_port int,
 	dst_port int,
 	) {
 
+	var failure error
 	defer func() {
-		close(doneCh)
 		conn.CloseRead()
+		doneCh <- failure
 	}()
 
-	sc.dec.Reset()
+	var dec Decoder
+	dec.Reset()
+	src, dst := strconv.Itoa(src_port), strconv.Itoa(dst_port)
 	for {
 		select {
 		case <-closeCh:
 			return
+		case <-abortCh:
+			return
 		default:
 		}
 		conn.SetReadDeadline(time.Now().Add(readTimeout))
-		buf, err := sc.dec.ReadFrom(conn)
+		buf, err := dec.ReadFrom(conn)
 		if buf != nil {
-			num := atomic.AddUint64(counter, 1)
-			crc := computeCrc64(buf)
-			msg := &Message{Seqnum: num, Crc64: crc, Payload: buf,
-				Src:strconv.Itoa(src_port), Dst:strconv.Itoa(dst_port)}
-			msg.DecideFn = func(outcome bool) { sc.decideOnMessage(msg, outcome, connLogger) }
+			msg := sc.receive(buf, src, dst, counter, connLogger)
 			connLogger.Debug("received Message", fieldsFor(msg)...)
-			sc.addMessage(msg)
-			msgChan <- *msg
+			sc.observer.received(msg)
+			if !intercept(msg) {
+				handOut(msg, abortCh)
+			}
 		}
 		if err != nil {
 			if isTimeout(err) {
 				continue
 			}
 			if isEOF(err) {
+				connLogger.Debug("peer half-closed connection")
+				sc.finish()
 				return
 			}
 			connLogger.Error("read() failed", zap.Error(err))
+			failure = err
 			return
 		}
 	}
 }
 
+// Writes the flow to the connection; reports nil once the flow drains or the connection
+// is half-closed or torn down, and the failure otherwise.
 func runConnectionWrite(
-	doneCh chan struct{},
+	doneCh chan<- error,
 	closeCh <-chan struct{},
+	abortCh <-chan struct{},
+	closeWriteCh <-chan struct{},
 	sc *semichannel,
 	conn *net.TCPConn,
 	connLogger zap.Logger,
 	) {
 
+	var failure error
 	defer func() {
-		close(doneCh)
 		conn.CloseWrite()
+		doneCh <- failure
 	}()
-	sc.enc.Reset()
+
+	var enc encoder
+	enc.Reset()
+	var writeMsg MessageI
 	for {
 		select {
 		case <-closeCh:
 			return
+		case <-abortCh:
+			return
+		case <-closeWriteCh:
+			return
 		default:
 		}
-		if sc.writeMsg == nil {
-			select {
-			case <-closeCh:
-				return
-			case sc.writeMsg = <-sc.writeCh:
-				connLogger.Debug("sending Message", fieldsFor(sc.writeMsg)...)
-				sc.enc.Next(sc.writeMsg.GetPayload())
+		if writeMsg != nil && atomic.CompareAndSwapInt32(&sc.dropFrame, 1, 0) {
+			connLogger.Info("dropping partially written Message", fieldsFor(writeMsg)...)
+			enc.Reset()
+			writeMsg = nil
+			atomic.StoreInt32(&sc.partial, 0)
+		}
+		if writeMsg == nil {
+			queued, ok := sc.writes.take()
+			if !ok {
+				if sc.drained() {
+					connLogger.Debug("flow drained, half-closing connection")
+					return
+				}
+				select {
+				case <-closeCh:
+					return
+				case <-abortCh:
+					return
+				case <-closeWriteCh:
+					return
+				case <-sc.writes.wait():
+				}
+				continue
 			}
+			writeMsg = queued.msg
+			if d := sc.shapingDelay(writeMsg.GetSize(), queued.at, time.Now()); d > 0 {
+				select {
+				case <-closeCh:
+					return
+				case <-abortCh:
+					return
+				case <-closeWriteCh:
+					return
+				case <-time.After(d):
+				}
+			}
+			connLogger.Debug("sending Message", fieldsFor(writeMsg)...)
+			enc.Next(writeMsg.GetPayload())
 		}
-		conn.SetDeadline(time.Now().Add(writeTimeout))
-		done, err := sc.enc.WriteTo(conn)
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		done, err := enc.WriteTo(conn)
 		if done {
-			connLogger.Debug("Message sent ", fieldsFor(sc.writeMsg)...)
-			sc.writeMsg = nil
+			connLogger.Debug("Message sent ", fieldsFor(writeMsg)...)
+			sc.observer.sent(writeMsg)
+			writeMsg = nil
+			// Request to drop the frame came too late.
+			atomic.StoreInt32(&sc.partial, 0)
+			atomic.StoreInt32(&sc.dropFrame, 0)
+		} else {
+			atomic.StoreInt32(&sc.partial, 1)
 		}
 		if err != nil {
 			if isTimeout(err) {
 				continue
 			}
 			connLogger.Error("write() failed", zap.Error(err))
+			failure = err
 			return
 		}
 	}
@@ -204,30 +526,73 @@ func runConnectionWrite(
 
 func NewChannel(name string, srcPort int, dstPort int, counter *uint64,
 				logger zap.Logger, msgChan chan Message) Channel {
-	chBuferCapacity := 100
+	timers := newTimerSet()
+	pending := newPendingQueue()
+	observer := &observerRef{}
 	c := &channel{
-		name:    name,
-		srcPort: srcPort,
-		dstPort: dstPort,
-		counter: counter,
-		logger:  *logger.With(zap.String("channel", name)),
-		closeCh: make(chan struct{}),
-		inbound: semichannel{
-			readQueue: make([]MessageI, 0),
-			writeCh:   make(chan MessageI, chBuferCapacity),
-		},
-		outbound: semichannel{
-			readQueue: make([]MessageI, 0),
-			writeCh:   make(chan MessageI, chBuferCapacity),
-		},
+		name:     name,
+		srcPort:  srcPort,
+		dstPort:  dstPort,
+		counter:  counter,
+		logger:   *logger.With(zap.String("channel", name)),
+		closeCh:  make(chan struct{}),
+		timers:   timers,
+		pending:  pending,
+		observer: observer,
+		conns:    make(map[*net.TCPConn]*connState),
 	}
 	c.closeWg.Add(2)
-	go c.runInboundFlow(msgChan)
-	go c.runOutboundFlow(msgChan)
+	go c.runInboundFlow()
+	go c.runHandout(msgChan)
 	return c
 }
 
-func (c *channel) runInboundFlow(msgChan chan Message) {
+// Hands pending messages out for manual decision as long as someone takes them.
+func (c *channel) runHandout(msgChan chan Message) {
+	defer c.closeWg.Done()
+
+	for {
+		msg := c.pending.next()
+		if msg == nil {
+			select {
+			case <-c.closeCh:
+				return
+			case <-c.pending.ready:
+			}
+			continue
+		}
+		select {
+		case <-c.closeCh:
+			return
+		case msgChan <- *msg:
+		}
+	}
+}
+
+// Queues Message for manual decision, applying overflow policy if the pending queue is full.
+func (c *channel) handOut(msg *Message, cancel <-chan struct{}) {
+	admitted, evicted, policy := c.pending.add(msg, cancel)
+	if admitted {
+		if evicted != nil {
+			c.logger.Info("pending queue overflow, rejecting oldest Message", fieldsFor(evicted)...)
+			evicted.Reject()
+		}
+		return
+	}
+	switch policy {
+	case OverflowAccept:
+		c.logger.Info("pending queue overflow, accepting Message", fieldsFor(msg)...)
+		msg.Accept()
+	case OverflowDropNewest:
+		c.logger.Info("pending queue overflow, rejecting Message", fieldsFor(msg)...)
+		msg.Reject()
+	default:
+		c.logger.Debug("connection closed while pending queue is full, rejecting Message", fieldsFor(msg)...)
+		msg.Reject()
+	}
+}
+
+func (c *channel) runInboundFlow() {
 	defer c.closeWg.Done()
 
 	addr := ":" + strconv.Itoa(c.srcPort)
@@ -256,12 +621,12 @@ func (c *channel) runInboundFlow(msgChan chan Message) {
 		listenerLogger.Debug("listening for incoming connection")
 
 		c.closeWg.Add(1)
-		// Blocking call here; every channel must have exactly once inbound connection.
-		c.runInboundListener(listener.(*net.TCPListener), *listenerLogger, msgChan)
+		// Blocking call here; listener serves every accepted connection until the channel is closed.
+		c.runInboundListener(listener.(*net.TCPListener), *listenerLogger)
 	}
 }
 
-func (c *channel) runInboundListener(listener *net.TCPListener, listenerLogger zap.Logger, msgChan chan Message) {
+func (c *channel) runInboundListener(listener *net.TCPListener, listenerLogger zap.Logger) {
 	defer c.closeWg.Done()
 
 	type acceptResult struct {
@@ -301,79 +666,132 @@ func (c *channel) runInboundListener(listener *net.TCPListener, listenerLogger z
 				zap.String("direction", "inbound"))
 			connLogger.Debug("accepted inbound connection")
 
+			if c.refusing() {
+				connLogger.Info("refusing inbound connection")
+				conn.SetLinger(0)
+				conn.Close()
+				continue
+			}
+
 			c.closeWg.Add(1)
-			// Blocking call here; every channel must have exactly once inbound connection.
-			c.runConnection(&c.inbound, conn, *connLogger, msgChan, true)
+			go c.runLink(conn, *connLogger)
 		}
 	}
 }
 
-func (c *channel) runOutboundFlow(msgChan chan Message) {
+// Proxies client connection accepted on source port through a connection
+// established for it towards destination port.
+func (c *channel) runLink(conn *net.TCPConn, connLogger zap.Logger) {
 	defer c.closeWg.Done()
 
+	l := c.addLink()
+	defer c.removeLink(l)
+
+	// Client is served right away, so that its messages are intercepted while
+	// destination is being connected to.
+	src := c.trackConnection(conn, true)
+	srcDone := make(chan struct{})
+	go func() {
+		defer close(srcDone)
+		c.runConnection(conn, src, connLogger, l.inbound, l.outbound, c.srcPort, c.dstPort)
+	}()
+
+	dstConn, dstLogger := c.dial(src.abortCh)
+	if dstConn == nil {
+		src.abort()
+		<-srcDone
+		return
+	}
+	dst := c.trackConnection(dstConn, false)
+	dstDone := make(chan struct{})
+	go func() {
+		defer close(dstDone)
+		c.runConnection(dstConn, dst, *dstLogger, l.outbound, l.inbound, c.dstPort, c.srcPort)
+	}()
+
+	// Either connection torn down tears down the other one.
+	linkDone := make(chan struct{})
+	defer close(linkDone)
+	go func() {
+		select {
+		case <-src.abortCh:
+		case <-dst.abortCh:
+		case <-linkDone:
+			return
+		}
+		src.abort()
+		dst.abort()
+	}()
+	<-srcDone
+	<-dstDone
+}
+
+// Connects to destination port, retrying until connection is established, the
+// link is torn down or the channel is closed; returns nil connection in the latter cases.
+func (c *channel) dial(abortCh <-chan struct{}) (*net.TCPConn, *zap.Logger) {
 	addr := ":" + strconv.Itoa(c.dstPort)
 
 	for {
 		select {
 		case <-c.closeCh:
-			c.logger.Debug("outbound flow terminated")
-			return
+			return nil, nil
+		case <-abortCh:
+			return nil, nil
 		default:
 		}
 
 		conn, err := net.DialTimeout("tcp", addr, backoffTimeout)
-		if err != nil {
-			c.logger.Error("connect() failed", zap.String("addr", addr), zap.Error(err))
-			select {
-			case <-c.closeCh:
-			case <-time.After(backoffTimeout):
-			}
-			continue
+		if err == nil {
+			connLogger := c.logger.With(
+				zap.String("localaddr", conn.LocalAddr().String()),
+				zap.String("remoteaddr", conn.RemoteAddr().String()),
+				zap.String("direction", "outbound"))
+			connLogger.Debug("established outbound connection")
+			return conn.(*net.TCPConn), connLogger
+		}
+		c.logger.Error("connect() failed", zap.String("addr", addr), zap.Error(err))
+		select {
+		case <-c.closeCh:
+			return nil, nil
+		case <-abortCh:
+			return nil, nil
+		case <-time.After(backoffTimeout):
 		}
-
-		connLogger := c.logger.With(
-			zap.String("localaddr", conn.LocalAddr().String()),
-			zap.String("remoteaddr", conn.RemoteAddr().String()),
-			zap.String("direction", "outbound"))
-		connLogger.Debug("established outbound connection")
-
-		c.closeWg.Add(1)
-		// Blocking call here; every channel must have exactly once outbound connection.
-		c.runConnection(&c.inbound, conn.(*net.TCPConn), *connLogger, msgChan, false)
 	}
 }
 
-func (c *channel) runConnection(sc *semichannel, conn *net.TCPConn, connLogger zap.Logger, msgChan chan Message, inbound bool) {
+// Serves a connection of a link: reads flow readSc from it and writes flow writeSc to it.
+func (c *channel) runConnection(conn *net.TCPConn, state *connState, connLogger zap.Logger,
+	readSc *semichannel, writeSc *semichannel, srcPort int, dstPort int) {
+	local, remote := conn.LocalAddr().String(), conn.RemoteAddr().String()
+	c.observer.connected(c.name, state.inbound, local, remote)
 	defer func() {
 		connLogger.Debug("closing connection")
+		c.untrackConnection(conn)
 		conn.Close()
-		c.closeWg.Done()
+		c.observer.disconnected(c.name, state.inbound, local, remote)
 	}()
 
-	readCh := make(chan struct{})
-	writeCh := make(chan struct{})
+	readCh := make(chan error, 1)
+	writeCh := make(chan error, 1)
 
-	if inbound {
-		go runConnectionRead(readCh, c.closeCh, c.counter, sc, conn, connLogger, msgChan, c.srcPort, c.dstPort)
-	} else {
-		go runConnectionWrite(writeCh, c.closeCh, sc, conn, connLogger)
-	}
+	go runConnectionRead(readCh, c.closeCh, state.abortCh, c.counter, readSc, conn, connLogger, c.intercept, c.handOut, srcPort, dstPort)
+	go runConnectionWrite(writeCh, c.closeCh, state.abortCh, state.closeWriteCh, writeSc, conn, connLogger)
 
-	for n := 2; n > 0; {
+	// Directions end on their own as the peers half-close; only a failure tears
+	// the connection down, along with the other connection of the link.
+	for n := 2; n > 0; n-- {
 		select {
-		case _, ok := <-readCh:
-			if !ok {
-				readCh = nil
-				n--
+		case err := <-readCh:
+			connLogger.Debug("Read ends", zap.Error(err))
+			if err != nil {
+				state.abort()
 			}
-			connLogger.Debug("Read ends", zap.Any("ok", ok))
-			return
-		case _, ok := <-writeCh:
-			if !ok {
-				writeCh = nil
-				n--
+		case err := <-writeCh:
+			connLogger.Debug("Write ends", zap.Error(err))
+			if err != nil {
+				state.abort()
 			}
-			connLogger.Debug("Write ends", zap.Any("ok", ok))
 		}
 	}
 }
@@ -390,9 +808,226 @@ func (c *channel) GetDstPort() int {
 	return c.dstPort
 }
 
+func (c *channel) SetInterceptor(interceptor Interceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interceptor = interceptor
+}
+
+func (c *channel) newSemichannel() *semichannel {
+	return &semichannel{
+		channel:   c.name,
+		readQueue: make([]MessageI, 0),
+		timers:    c.timers,
+		pending:   c.pending,
+		observer:  c.observer,
+	}
+}
+
+// Creates link for a client, configured like the others.
+func (c *channel) addLink() *link {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	l := &link{inbound: c.newSemichannel(), outbound: c.newSemichannel()}
+	for _, sc := range l.flows() {
+		if c.reorder.Mode != ReorderNone {
+			sc.hold = newHoldBuffer(c.reorder)
+		}
+		if c.shaping.enabled() {
+			sc.shaper = newShaper(c.shaping)
+		}
+	}
+	c.links = append(c.links, l)
+	return l
+}
+
+func (c *channel) removeLink(l *link) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	for i, other := range c.links {
+		if other == l {
+			c.links = append(c.links[:i], c.links[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns flows of every link.
+func (c *channel) flows() []*semichannel {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	flows := make([]*semichannel, 0, 2*len(c.links))
+	for _, l := range c.links {
+		flows = append(flows, l.flows()...)
+	}
+	return flows
+}
+
+func (l *link) flows() []*semichannel {
+	return []*semichannel{l.inbound, l.outbound}
+}
+
+func (c *channel) SetReorder(config Reorder) {
+	c.connMu.Lock()
+	c.reorder = config
+	c.connMu.Unlock()
+
+	// Links established from now on are configured already.
+	for _, sc := range c.flows() {
+		sc.setReorder(config, c.logger)
+	}
+}
+
+func (c *channel) Release(order []uint64) int {
+	released := 0
+	for _, sc := range c.flows() {
+		released += sc.releaseHeld(order, c.logger)
+	}
+	return released
+}
+
+func (c *channel) Inject(payload []byte) (MessageI, error) {
+	if len(payload) > maxPayload {
+		return nil, maxPayloadError
+	}
+	c.connMu.Lock()
+	var l *link
+	if len(c.links) > 0 {
+		l = c.links[len(c.links)-1]
+	}
+	c.connMu.Unlock()
+	if l == nil {
+		return nil, noConnectionError
+	}
+
+	msg := &Message{Seqnum: atomic.AddUint64(c.counter, 1), Crc64: computeCrc64(payload), Payload: payload,
+		Channel: c.name, Src: strconv.Itoa(c.srcPort), Dst: strconv.Itoa(c.dstPort), Injected: true, timers: c.timers,
+		logger: &c.logger}
+	l.inbound.inject(msg, c.logger)
+	return msg, nil
+}
+
+func (c *channel) trackConnection(conn *net.TCPConn, inbound bool) *connState {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	state := &connState{inbound: inbound, abortCh: make(chan struct{}), closeWriteCh: make(chan struct{})}
+	c.conns[conn] = state
+	return state
+}
+
+func (c *channel) untrackConnection(conn *net.TCPConn) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	delete(c.conns, conn)
+}
+
+func (c *channel) refusing() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	return time.Now().Before(c.refuseUntil)
+}
+
+func (c *channel) ResetConnections() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	for conn, state := range c.conns {
+		c.logger.Info("resetting connection",
+			zap.String("localaddr", conn.LocalAddr().String()),
+			zap.String("remoteaddr", conn.RemoteAddr().String()))
+		// Zero linger turns close into abort, so that the peer receives RST instead of FIN.
+		conn.SetLinger(0)
+		conn.Close()
+		state.abort()
+		delete(c.conns, conn)
+	}
+}
+
+func (c *channel) HalfCloseConnections(inbound bool) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	for conn, state := range c.conns {
+		if state.inbound == inbound {
+			c.logger.Info("half-closing connection",
+				zap.String("localaddr", conn.LocalAddr().String()),
+				zap.String("remoteaddr", conn.RemoteAddr().String()))
+			// Writer leaves on its own, shutting down the writing half of the connection.
+			state.closeWrite()
+		}
+	}
+}
+
+func (c *channel) RefuseConnections(d time.Duration) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	c.refuseUntil = time.Now().Add(d)
+	c.logger.Info("refusing inbound connections", zap.Time("until", c.refuseUntil))
+}
+
+func (c *channel) DropPartialFrame() int {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	// Only frames towards destination are abandoned; responses to clients are left intact.
+	dropped := 0
+	for _, l := range c.links {
+		if atomic.LoadInt32(&l.inbound.partial) == 1 {
+			atomic.StoreInt32(&l.inbound.dropFrame, 1)
+			dropped++
+		}
+	}
+	c.logger.Info("dropping partially written frames", zap.Int("frames", dropped))
+	return dropped
+}
+
+func (c *channel) SetShaping(config Shaping) {
+	c.connMu.Lock()
+	c.shaping = config
+	c.connMu.Unlock()
+
+	for _, sc := range c.flows() {
+		sc.setShaping(config)
+	}
+}
+
+func (c *channel) SetPendingLimit(capacity int, policy OverflowPolicy) {
+	c.pending.setLimit(capacity, policy)
+}
+
+func (c *channel) Pending() []MessageI {
+	return c.pending.snapshot()
+}
+
+func (c *channel) SetObserver(observer Observer) {
+	c.observer.set(observer)
+}
+
+func (c *channel) intercept(msg *Message) bool {
+	c.mu.RLock()
+	interceptor := c.interceptor
+	c.mu.RUnlock()
+
+	if interceptor == nil {
+		return false
+	}
+	return interceptor.Intercept(msg)
+}
+
 func (c *channel) Close() {
 	c.logger.Debug("closing channel")
 	close(c.closeCh)
+	// Timers go once connections are told to stop, so that scheduled decisions
+	// running meanwhile find nobody waiting for them.
+	c.timers.close()
 	c.closeWg.Wait()
 	c.logger.Debug("channel closed")
 }
@@ -0,0 +1,326 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Returns port nobody listens on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Connects to the port, waiting for the channel to start listening on it.
+func dialChannel(t *testing.T, port int) *net.TCPConn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return conn.(*net.TCPConn)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cannot connect to channel: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func acceptChannel(t *testing.T, l *net.TCPListener) *net.TCPConn {
+	l.SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatalf("channel did not connect: %v", err)
+	}
+	return conn
+}
+
+func writeFrame(t *testing.T, conn net.Conn, payload []byte) {
+	var enc encoder
+	enc.Next(payload)
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := enc.WriteTo(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func readFrame(t *testing.T, conn net.Conn, dec *Decoder) []byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	payload, err := dec.ReadFrom(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return payload
+}
+
+func nextMessage(t *testing.T, msgChan chan Message) Message {
+	select {
+	case msg := <-msgChan:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no Message handed out")
+		return Message{}
+	}
+}
+
+func TestChannelDuplicatesBeyondWriteBuffer(t *testing.T) {
+	dst, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dst.Close()
+	srcPort, dstPort := freePort(t), dst.Addr().(*net.TCPAddr).Port
+
+	msgChan := make(chan Message, 10)
+	counter := uint64(0)
+	c := NewChannel("test", srcPort, dstPort, &counter, *zap.NewNop(), msgChan)
+	defer c.Close()
+
+	client := dialChannel(t, srcPort)
+	defer client.Close()
+	server := acceptChannel(t, dst)
+	defer server.Close()
+
+	writeFrame(t, client, []byte("x"))
+	msg := nextMessage(t, msgChan)
+	// Copies outnumber anything the writer could buffer, while the writer needs
+	// the shaper on every frame.
+	c.SetShaping(Shaping{Bandwidth: 1 << 30})
+	msg.Duplicate(300)
+
+	var dec Decoder
+	dec.Reset()
+	for i := 0; i <= 300; i++ {
+		if payload := readFrame(t, server, &dec); !bytes.Equal(payload, []byte("x")) {
+			t.Fatalf("unexpected payload of frame %d: %q", i, payload)
+		}
+	}
+}
+
+func TestChannelCloseWithTimedAcceptance(t *testing.T) {
+	// Nobody listens on destination port, so nothing is ever written.
+	srcPort, dstPort := freePort(t), freePort(t)
+	msgChan := make(chan Message, 10)
+	counter := uint64(0)
+	c := NewChannel("test", srcPort, dstPort, &counter, *zap.NewNop(), msgChan)
+
+	client := dialChannel(t, srcPort)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		writeFrame(t, client, []byte("x"))
+		msg := nextMessage(t, msgChan)
+		msg.AcceptAfter(time.Duration(i) * 10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close hangs")
+	}
+}
+
+type acceptAll struct{}
+
+func (acceptAll) Intercept(msg *Message) bool {
+	msg.Accept()
+	return true
+}
+
+// Replies to every frame on the connection it came from.
+func runEchoServer(t *testing.T, l *net.TCPListener) {
+	for {
+		conn, err := l.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var dec Decoder
+			dec.Reset()
+			for {
+				payload, err := dec.ReadFrom(conn)
+				if payload != nil {
+					var enc encoder
+					enc.Next(append([]byte("re:"), payload...))
+					if _, err := enc.WriteTo(conn); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestChannelServesConcurrentClients(t *testing.T) {
+	dst, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dst.Close()
+	go runEchoServer(t, dst)
+	srcPort, dstPort := freePort(t), dst.Addr().(*net.TCPAddr).Port
+
+	msgChan := make(chan Message, 10)
+	counter := uint64(0)
+	c := NewChannel("test", srcPort, dstPort, &counter, *zap.NewNop(), msgChan)
+	defer c.Close()
+	c.SetInterceptor(acceptAll{})
+
+	clients := []*net.TCPConn{dialChannel(t, srcPort), dialChannel(t, srcPort)}
+	for _, client := range clients {
+		defer client.Close()
+	}
+
+	errs := make(chan error, len(clients))
+	for i, client := range clients {
+		go func(i int, client *net.TCPConn) {
+			var dec Decoder
+			dec.Reset()
+			for j := 0; j < 10; j++ {
+				request := []byte("client " + strconv.Itoa(i) + " request " + strconv.Itoa(j))
+				var enc encoder
+				enc.Next(request)
+				client.SetDeadline(time.Now().Add(5 * time.Second))
+				if _, err := enc.WriteTo(client); err != nil {
+					errs <- err
+					return
+				}
+				reply, err := dec.ReadFrom(client)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if expected := append([]byte("re:"), request...); !bytes.Equal(reply, expected) {
+					errs <- fmt.Errorf("expected %q, got %q", expected, reply)
+					return
+				}
+			}
+			errs <- nil
+		}(i, client)
+	}
+	for range clients {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestChannelPropagatesHalfClose(t *testing.T) {
+	dst, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dst.Close()
+	srcPort, dstPort := freePort(t), dst.Addr().(*net.TCPAddr).Port
+
+	msgChan := make(chan Message, 10)
+	counter := uint64(0)
+	c := NewChannel("test", srcPort, dstPort, &counter, *zap.NewNop(), msgChan)
+	defer c.Close()
+	c.SetInterceptor(acceptAll{})
+
+	client := dialChannel(t, srcPort)
+	defer client.Close()
+	writeFrame(t, client, []byte("request"))
+	client.CloseWrite()
+
+	// Server answers once the request is over, as told by FIN.
+	server := acceptChannel(t, dst)
+	defer server.Close()
+	var dec Decoder
+	dec.Reset()
+	if payload := readFrame(t, server, &dec); !bytes.Equal(payload, []byte("request")) {
+		t.Fatalf("unexpected request %q", payload)
+	}
+	if _, err := dec.ReadFrom(server); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	writeFrame(t, server, []byte("response"))
+	server.CloseWrite()
+
+	dec.Reset()
+	if payload := readFrame(t, client, &dec); !bytes.Equal(payload, []byte("response")) {
+		t.Fatalf("unexpected response %q", payload)
+	}
+	if _, err := dec.ReadFrom(client); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestChannelDropPartialFrame(t *testing.T) {
+	dst, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dst.Close()
+	srcPort, dstPort := freePort(t), dst.Addr().(*net.TCPAddr).Port
+
+	msgChan := make(chan Message, 10)
+	counter := uint64(0)
+	c := NewChannel("test", srcPort, dstPort, &counter, *zap.NewNop(), msgChan)
+	defer c.Close()
+	c.SetInterceptor(acceptAll{})
+
+	client := dialChannel(t, srcPort)
+	defer client.Close()
+	server := acceptChannel(t, dst)
+	defer server.Close()
+
+	// Nothing is written at the moment, so the request is refused rather than kept.
+	writeFrame(t, client, []byte("x"))
+	var dec Decoder
+	dec.Reset()
+	readFrame(t, server, &dec)
+	if n := c.DropPartialFrame(); n != 0 {
+		t.Fatalf("expected no frame to be dropped, got %v", n)
+	}
+	writeFrame(t, client, []byte("y"))
+	if payload := readFrame(t, server, &dec); !bytes.Equal(payload, []byte("y")) {
+		t.Fatalf("unexpected payload %q", payload)
+	}
+
+	// Server stops reading, so frames get stuck half way.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		var enc encoder
+		payload := make([]byte, maxPayload)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			enc.Next(payload)
+			client.SetWriteDeadline(time.Now().Add(time.Second))
+			if _, err := enc.WriteTo(client); err != nil && !isTimeout(err) {
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for c.DropPartialFrame() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no frame got stuck")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,198 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distribution of the delay applied to accepted messages.
+type Delay interface {
+	// Draws the next delay from the distribution.
+	Next(r *rand.Rand) time.Duration
+	String() string
+}
+
+type fixedDelay struct {
+	d time.Duration
+}
+
+type uniformDelay struct {
+	min time.Duration
+	max time.Duration
+}
+
+type exponentialDelay struct {
+	mean time.Duration
+}
+
+type paretoDelay struct {
+	scale time.Duration
+	shape float64
+}
+
+var invalidDelayError = errors.New("invalid delay specification")
+
+func (d fixedDelay) Next(r *rand.Rand) time.Duration {
+	return d.d
+}
+
+func (d fixedDelay) String() string {
+	return "fixed:" + d.d.String()
+}
+
+func (d uniformDelay) Next(r *rand.Rand) time.Duration {
+	return d.min + time.Duration(r.Int63n(int64(d.max-d.min)+1))
+}
+
+func (d uniformDelay) String() string {
+	return "uniform:" + d.min.String() + ":" + d.max.String()
+}
+
+func (d exponentialDelay) Next(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(d.mean))
+}
+
+func (d exponentialDelay) String() string {
+	return "exponential:" + d.mean.String()
+}
+
+func (d paretoDelay) Next(r *rand.Rand) time.Duration {
+	// Inverse transform sampling; 1 - Float64() lies in (0, 1].
+	v := float64(d.scale) / math.Pow(1-r.Float64(), 1/d.shape)
+	// Heavy tails overflow Duration, which would turn the longest delays into none.
+	if v >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(v)
+}
+
+func (d paretoDelay) String() string {
+	return "pareto:" + d.scale.String() + ":" + strconv.FormatFloat(d.shape, 'g', -1, 64)
+}
+
+// Parses delay distribution in one of the forms:
+// "fixed:D", "uniform:MIN:MAX", "exponential:MEAN", "pareto:SCALE:SHAPE".
+// Empty string stands for no delay.
+func ParseDelay(spec string) (Delay, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(spec, ":")
+	durations := make([]time.Duration, 0, len(parts)-1)
+	parseArgs := func(n int) error {
+		if len(parts) != n+1 {
+			return fmt.Errorf("%v: %q expects %d argument(s)", invalidDelayError, parts[0], n)
+		}
+		for _, arg := range parts[1:] {
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return fmt.Errorf("%v: %v", invalidDelayError, err)
+			}
+			if d < 0 {
+				return fmt.Errorf("%v: negative duration %v", invalidDelayError, d)
+			}
+			durations = append(durations, d)
+		}
+		return nil
+	}
+
+	switch parts[0] {
+	case "fixed":
+		if err := parseArgs(1); err != nil {
+			return nil, err
+		}
+		return fixedDelay{durations[0]}, nil
+	case "uniform":
+		if err := parseArgs(2); err != nil {
+			return nil, err
+		}
+		if durations[0] > durations[1] {
+			return nil, fmt.Errorf("%v: min exceeds max", invalidDelayError)
+		}
+		return uniformDelay{durations[0], durations[1]}, nil
+	case "exponential":
+		if err := parseArgs(1); err != nil {
+			return nil, err
+		}
+		return exponentialDelay{durations[0]}, nil
+	case "pareto":
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%v: %q expects 2 arguments", invalidDelayError, parts[0])
+		}
+		scale, err := time.ParseDuration(parts[1])
+		if err != nil || scale <= 0 {
+			return nil, fmt.Errorf("%v: bad scale %q", invalidDelayError, parts[1])
+		}
+		shape, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || !(shape > 0) || math.IsInf(shape, 1) {
+			return nil, fmt.Errorf("%v: bad shape %q", invalidDelayError, parts[2])
+		}
+		return paretoDelay{scale, shape}, nil
+	default:
+		return nil, fmt.Errorf("%v: unknown distribution %q", invalidDelayError, parts[0])
+	}
+}
+
+// Fault model decides on every intercepted Message automatically,
+// dropping, duplicating and delaying messages at random.
+type FaultModel struct {
+	DropProbability      float64
+	DuplicateProbability float64
+	Delay                Delay
+
+	mu  sync.Mutex // protects rnd
+	rnd *rand.Rand
+}
+
+type faultAction struct {
+	copies int // 0 stands for drop
+	delay  time.Duration
+}
+
+func NewFaultModel(drop float64, duplicate float64, delay Delay, seed int64) *FaultModel {
+	return &FaultModel{
+		DropProbability:      drop,
+		DuplicateProbability: duplicate,
+		Delay:                delay,
+		rnd:                  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Draws the fate of the next Message. Draws are made in a fixed order,
+// so that equal seeds produce equal sequences of actions.
+func (f *FaultModel) next() faultAction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rnd.Float64() < f.DropProbability {
+		return faultAction{}
+	}
+	action := faultAction{copies: 1}
+	if f.rnd.Float64() < f.DuplicateProbability {
+		action.copies++
+	}
+	if f.Delay != nil {
+		action.delay = f.Delay.Next(f.rnd)
+	}
+	return action
+}
+
+func (f *FaultModel) Intercept(msg *Message) bool {
+	action := f.next()
+	switch {
+	case action.copies == 0:
+		msg.Reject()
+	case action.delay > 0:
+		msg.timers.after(action.delay, func() { msg.accept(action.copies) })
+	default:
+		msg.accept(action.copies)
+	}
+	return true
+}
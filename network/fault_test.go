@@ -0,0 +1,63 @@
+package network
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseDelay(t *testing.T) {
+	valid := []string{"fixed:10ms", "uniform:1ms:5ms", "exponential:20ms", "pareto:1ms:1.5"}
+	for _, spec := range valid {
+		d, err := ParseDelay(spec)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", spec, err)
+		}
+		if d.String() != spec {
+			t.Errorf("unmatched spec: actual %v, expected %v", d.String(), spec)
+		}
+	}
+
+	invalid := []string{"fixed", "fixed:x", "uniform:5ms:1ms", "pareto:1ms:0", "pareto:1ms:NaN", "pareto:1ms:Inf",
+		"normal:1ms"}
+	for _, spec := range invalid {
+		if _, err := ParseDelay(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+
+	if d, err := ParseDelay(""); d != nil || err != nil {
+		t.Errorf("unexpected result for empty spec: %v, %v", d, err)
+	}
+}
+
+func TestUniformDelayBounds(t *testing.T) {
+	d := uniformDelay{time.Millisecond, 2 * time.Millisecond}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		if v := d.Next(r); v < d.min || v > d.max {
+			t.Fatalf("delay out of bounds: %v", v)
+		}
+	}
+}
+
+func TestParetoDelayHeavyTail(t *testing.T) {
+	d := paretoDelay{time.Millisecond, 0.1}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		if v := d.Next(r); v <= 0 {
+			t.Fatalf("non-positive delay: %v", v)
+		}
+	}
+}
+
+func TestFaultModelSeeded(t *testing.T) {
+	delay, _ := ParseDelay("exponential:10ms")
+	f1 := NewFaultModel(0.3, 0.3, delay, 42)
+	f2 := NewFaultModel(0.3, 0.3, delay, 42)
+	for i := 0; i < 100; i++ {
+		if a1, a2 := f1.next(), f2.next(); a1 != a2 {
+			t.Fatalf("unmatched action %v: %v vs %v", i, a1, a2)
+		}
+	}
+}
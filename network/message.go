@@ -1,30 +1,74 @@
 package network
 
-import "hash/crc64"
+import (
+	"errors"
+	"go.uber.org/zap"
+	"hash/crc64"
+	"time"
+)
 
 // In-flight Message, intercepted by the channel.
 type MessageI interface {
 	GetSeqNum() uint64
+	// Returns sequence number of the original Message if this one is a duplicate, zero otherwise.
+	GetOrigin() uint64
 	GetCrc64() uint64
 	GetPayload() []byte
 	GetSize() int
+	// Returns true if payload was modified while in flight.
+	IsTampered() bool
+	// Returns true if Message was forged rather than produced by a node.
+	IsInjected() bool
+	// Returns name of the channel Message travels through.
+	GetChannel() string
+	// Returns source port of Message.
+	GetSrc() string
+	// Returns destination port of Message.
+	GetDst() string
 
 	// Enqueues Message for a subsequent write operation for eventual delivery.
 	Accept()
 	// Discards Message, preventing its delivery.
 	Reject()
+	// Enqueues Message followed by n copies of it, delivering the payload n+1 times;
+	// n is capped by MaxDuplicates.
+	Duplicate(n int)
+	// Accepts Message once the given duration elapses.
+	AcceptAfter(d time.Duration)
+	// Accepts Message at the given point in time; past deadlines accept immediately.
+	AcceptAt(deadline time.Time)
+	// Replaces payload of pending Message; Message stays pending until decided on.
+	// Fails if payload exceeds the frame limit or Message is decided already.
+	Modify(payload []byte) error
 }
 
 type Message struct {
 	Seqnum  uint64
+	Origin  uint64
 	Crc64   uint64
 	Payload []byte
+	// Set once payload is modified in flight.
+	Tampered bool
+	// Set if Message was forged rather than produced by a node.
+	Injected bool
 
-	DecideFn func(bool)
+	// Decides on Message, enqueueing it given number of times; zero discards Message.
+	DecideFn func(copies int)
+	Channel string
 	Src string
 	Dst string
+
+	timers   *timerSet
+	logger   *zap.Logger
+	// Modifies Message in its flow; returns the Message kept there.
+	modifyFn func(payload []byte) (*Message, error)
 }
 
+var decidedError = errors.New("message is decided already")
+
+// Upper bound of copies made by a single Duplicate decision.
+const MaxDuplicates = 1000
+
 func computeCrc64(data []byte) uint64 {
 	return crc64.Checksum(data, crc64.MakeTable(crc64.ISO))
 }
@@ -33,6 +77,10 @@ func (m *Message) GetSeqNum() uint64 {
 	return m.Seqnum
 }
 
+func (m *Message) GetOrigin() uint64 {
+	return m.Origin
+}
+
 func (m *Message) GetCrc64() uint64 {
 	return m.Crc64
 }
@@ -45,10 +93,80 @@ func (m *Message) GetSize() int {
 	return len(m.Payload)
 }
 
+func (m *Message) IsTampered() bool {
+	return m.Tampered
+}
+
+func (m *Message) IsInjected() bool {
+	return m.Injected
+}
+
+func (m *Message) GetChannel() string {
+	return m.Channel
+}
+
+func (m *Message) GetSrc() string {
+	return m.Src
+}
+
+func (m *Message) GetDst() string {
+	return m.Dst
+}
+
+func (m *Message) Modify(payload []byte) error {
+	if len(payload) > maxPayload {
+		return maxPayloadError
+	}
+	// Injected messages and copies are decided on as soon as they appear.
+	if m.modifyFn == nil {
+		return decidedError
+	}
+	orig, err := m.modifyFn(payload)
+	if err != nil {
+		return err
+	}
+	// Message kept in the flow is modified under the lock of the flow already.
+	if m != orig {
+		m.setPayload(payload)
+	}
+	return nil
+}
+
+func (m *Message) setPayload(payload []byte) {
+	m.Payload = payload
+	m.Crc64 = computeCrc64(payload)
+	m.Tampered = true
+}
+
 func (m *Message) Accept() {
-	m.DecideFn(true)
+	m.accept(1)
 }
 
 func (m *Message) Reject() {
-	m.DecideFn(false)
+	m.DecideFn(0)
+}
+
+func (m *Message) Duplicate(n int) {
+	if n < 0 {
+		n = 0
+	} else if n > MaxDuplicates {
+		n = MaxDuplicates
+	}
+	m.accept(n + 1)
+}
+
+func (m *Message) AcceptAfter(d time.Duration) {
+	m.AcceptAt(time.Now().Add(d))
+}
+
+func (m *Message) AcceptAt(deadline time.Time) {
+	if m.timers.at(deadline, m.Accept) == nil && m.logger != nil {
+		// Message stays pending, as nobody is going to write it anyway.
+		m.logger.Warn("channel is closed, ignoring timed acceptance",
+			append(fieldsFor(m), zap.Time("deadline", deadline))...)
+	}
+}
+
+func (m *Message) accept(copies int) {
+	m.DecideFn(copies)
 }
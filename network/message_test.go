@@ -0,0 +1,131 @@
+package network
+
+import (
+	"bytes"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func newTestMessage(sc *semichannel, counter *uint64, payload []byte) *Message {
+	return sc.receive(payload, "", "", counter, *zap.NewNop())
+}
+
+// Takes messages enqueued for writing.
+func written(sc *semichannel) []MessageI {
+	var msgs []MessageI
+	for {
+		queued, ok := sc.writes.take()
+		if !ok {
+			return msgs
+		}
+		msgs = append(msgs, queued.msg)
+	}
+}
+
+func TestMessageDuplicate(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+	msg := newTestMessage(sc, &counter, []byte{'x'})
+
+	msg.Duplicate(2)
+	msg.Accept() // ignored, already decided
+
+	msgs := written(sc)
+	if n := len(msgs); n != 3 {
+		t.Fatalf("unexpected number of enqueued messages: %v", n)
+	}
+	if msgs[0] != MessageI(msg) {
+		t.Fatalf("original Message must be enqueued first")
+	}
+	for expected := uint64(2); expected <= 3; expected++ {
+		dup := msgs[expected-1]
+		if dup.GetSeqNum() != expected || dup.GetOrigin() != msg.Seqnum || dup.GetCrc64() != msg.Crc64 {
+			t.Errorf("unexpected duplicate: Seqnum %v, Origin %v", dup.GetSeqNum(), dup.GetOrigin())
+		}
+	}
+
+	newTestMessage(sc, &counter, []byte{'x'}).Duplicate(MaxDuplicates + 1)
+	if n := len(written(sc)); n != MaxDuplicates+1 {
+		t.Errorf("number of copies must be capped, got %v messages", n)
+	}
+}
+
+func TestMessageModify(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+	msg := newTestMessage(sc, &counter, []byte{'x'})
+
+	// Decisions arrive on copies of Message, e.g. from websocket sessions.
+	view := *msg
+	if err := view.Modify([]byte{'y'}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !msg.Tampered || !view.Tampered || msg.Crc64 != computeCrc64([]byte{'y'}) || view.Crc64 != msg.Crc64 {
+		t.Fatalf("Message was not modified: %+v", msg)
+	}
+
+	view.Accept()
+	view.Modify([]byte{'z'})
+	if sent := written(sc); len(sent) != 1 || !bytes.Equal(sent[0].GetPayload(), []byte{'y'}) {
+		t.Fatalf("unexpected payload: %v", sent)
+	}
+	if !bytes.Equal(msg.Payload, []byte{'y'}) {
+		t.Fatalf("decided Message must not be modified")
+	}
+}
+
+func TestMessageModifyOversized(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+	msg := newTestMessage(sc, &counter, []byte{'x'})
+
+	if err := msg.Modify(make([]byte, maxPayload+1)); err != maxPayloadError {
+		t.Fatalf("expected %v, got %v", maxPayloadError, err)
+	}
+	if msg.Tampered || !bytes.Equal(msg.Payload, []byte{'x'}) {
+		t.Fatalf("Message must not be modified")
+	}
+	msg.Accept()
+	if err := msg.Modify([]byte{'y'}); err != decidedError {
+		t.Fatalf("expected %v, got %v", decidedError, err)
+	}
+}
+
+func TestMessageModifyDecided(t *testing.T) {
+	// Injected messages and copies have no flow to be modified in.
+	msg := &Message{Seqnum: 1, Payload: []byte{'x'}, Injected: true}
+	if err := msg.Modify([]byte{'y'}); err != decidedError {
+		t.Fatalf("expected %v, got %v", decidedError, err)
+	}
+	if msg.Tampered || !bytes.Equal(msg.Payload, []byte{'x'}) {
+		t.Fatalf("Message must not be modified")
+	}
+}
+
+func TestReceiveConcurrent(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				newTestMessage(sc, &counter, []byte{'x'})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(sc.readQueue); n != 400 {
+		t.Fatalf("unexpected read queue length: %v", n)
+	}
+	if !sort.SliceIsSorted(sc.readQueue, func(i, j int) bool {
+		return sc.readQueue[i].GetSeqNum() < sc.readQueue[j].GetSeqNum()
+	}) {
+		t.Fatalf("read queue must be sorted by Seqnum")
+	}
+}
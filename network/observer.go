@@ -0,0 +1,127 @@
+package network
+
+import "sync"
+
+// Gets notified about messages passing through a channel. Notifications are
+// delivered synchronously, possibly with channel locks held, so observers must
+// not call back into the channel.
+type Observer interface {
+	// Message was read from a connection, injected or duplicated.
+	Received(msg MessageI)
+	// Payload of a pending Message was replaced.
+	Modified(msg MessageI)
+	// Message was decided on; zero copies stands for rejection.
+	Decided(msg MessageI, copies int)
+	// Message was written to a connection.
+	Sent(msg MessageI)
+}
+
+// Observer shared by the channel and its semichannels.
+type observerRef struct {
+	mu       sync.RWMutex // protects observer
+	observer Observer
+}
+
+func (r *observerRef) set(observer Observer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.observer = observer
+}
+
+func (r *observerRef) get() Observer {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.observer
+}
+
+func (r *observerRef) received(msg MessageI) {
+	if observer := r.get(); observer != nil {
+		observer.Received(msg)
+	}
+}
+
+func (r *observerRef) modified(msg MessageI) {
+	if observer := r.get(); observer != nil {
+		observer.Modified(msg)
+	}
+}
+
+func (r *observerRef) decided(msg MessageI, copies int) {
+	if observer := r.get(); observer != nil {
+		observer.Decided(msg, copies)
+	}
+}
+
+func (r *observerRef) sent(msg MessageI) {
+	if observer := r.get(); observer != nil {
+		observer.Sent(msg)
+	}
+}
+
+// Observer interested in connections of the channel as well.
+type ConnObserver interface {
+	// Connection was established; inbound connections are accepted on source port.
+	Connected(channel string, inbound bool, local string, remote string)
+	// Connection was closed.
+	Disconnected(channel string, inbound bool, local string, remote string)
+}
+
+// Observers notified in order.
+type Observers []Observer
+
+func (observers Observers) Received(msg MessageI) {
+	for _, observer := range observers {
+		observer.Received(msg)
+	}
+}
+
+func (observers Observers) Modified(msg MessageI) {
+	for _, observer := range observers {
+		observer.Modified(msg)
+	}
+}
+
+func (observers Observers) Decided(msg MessageI, copies int) {
+	for _, observer := range observers {
+		observer.Decided(msg, copies)
+	}
+}
+
+func (observers Observers) Sent(msg MessageI) {
+	for _, observer := range observers {
+		observer.Sent(msg)
+	}
+}
+
+func (observers Observers) Connected(channel string, inbound bool, local string, remote string) {
+	for _, observer := range observers {
+		if connObserver, ok := observer.(ConnObserver); ok {
+			connObserver.Connected(channel, inbound, local, remote)
+		}
+	}
+}
+
+func (observers Observers) Disconnected(channel string, inbound bool, local string, remote string) {
+	for _, observer := range observers {
+		if connObserver, ok := observer.(ConnObserver); ok {
+			connObserver.Disconnected(channel, inbound, local, remote)
+		}
+	}
+}
+
+func (r *observerRef) connected(channel string, inbound bool, local string, remote string) {
+	if connObserver, ok := r.get().(ConnObserver); ok {
+		connObserver.Connected(channel, inbound, local, remote)
+	}
+}
+
+func (r *observerRef) disconnected(channel string, inbound bool, local string, remote string) {
+	if connObserver, ok := r.get().(ConnObserver); ok {
+		connObserver.Disconnected(channel, inbound, local, remote)
+	}
+}
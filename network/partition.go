@@ -0,0 +1,205 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type PartitionPolicy int
+
+const (
+	// Messages crossing a partition boundary are rejected.
+	PartitionReject PartitionPolicy = iota
+	// Messages crossing a partition boundary are held until the partition heals.
+	PartitionHold
+)
+
+func ParsePartitionPolicy(s string) (PartitionPolicy, error) {
+	switch s {
+	case "", "reject":
+		return PartitionReject, nil
+	case "hold":
+		return PartitionHold, nil
+	default:
+		return PartitionReject, fmt.Errorf("unknown partition policy %q", s)
+	}
+}
+
+// Directed cut: traffic from any of the source ports to any of the destination ports is blocked.
+type cut struct {
+	from map[int]bool
+	to   map[int]bool
+}
+
+type partition struct {
+	groups [][]int
+	oneWay bool
+	cuts   []cut
+	held   []MessageI
+}
+
+// Set of named network partitions shared by all channels. Nodes are identified
+// by groups of ports; Message crosses a partition boundary if its source and
+// destination ports lie in groups separated by the partition.
+type Partitions struct {
+	logger zap.Logger
+	policy PartitionPolicy
+
+	mu         sync.Mutex // protects partitions
+	partitions map[string]*partition
+}
+
+var unknownPartitionError = errors.New("unknown partition")
+
+func NewPartitions(policy PartitionPolicy, logger zap.Logger) *Partitions {
+	return &Partitions{
+		logger:     logger,
+		policy:     policy,
+		partitions: make(map[string]*partition),
+	}
+}
+
+func portSet(ports []int) map[int]bool {
+	set := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		set[port] = true
+	}
+	return set
+}
+
+// Creates partition separating given groups of ports. Symmetric partition cuts
+// traffic between every pair of groups; one-way partition cuts traffic only from
+// every group towards the groups following it.
+func (p *Partitions) Partition(name string, groups [][]int, oneWay bool) error {
+	if len(groups) < 2 {
+		return fmt.Errorf("partition requires at least two groups of ports")
+	}
+	seen := make(map[int]bool)
+	for _, group := range groups {
+		for _, port := range group {
+			if seen[port] {
+				return fmt.Errorf("port %d belongs to several groups", port)
+			}
+			seen[port] = true
+		}
+	}
+
+	part := &partition{groups: groups, oneWay: oneWay}
+	for i := range groups {
+		for j := range groups {
+			if i == j || (oneWay && i > j) {
+				continue
+			}
+			part.cuts = append(part.cuts, cut{from: portSet(groups[i]), to: portSet(groups[j])})
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.partitions[name]; ok {
+		return fmt.Errorf("partition %q already exists", name)
+	}
+	p.partitions[name] = part
+	p.logger.Info("network partitioned",
+		zap.String("partition", name),
+		zap.Any("groups", groups),
+		zap.Bool("oneWay", oneWay))
+	return nil
+}
+
+// Heals named partition, or all of them if name is empty. Held messages are
+// accepted if release is set and rejected otherwise. Returns number of held messages.
+func (p *Partitions) Heal(name string, release bool) (int, error) {
+	p.mu.Lock()
+	var healed []string
+	if name == "" {
+		for n := range p.partitions {
+			healed = append(healed, n)
+		}
+		sort.Strings(healed)
+	} else if _, ok := p.partitions[name]; ok {
+		healed = append(healed, name)
+	} else {
+		p.mu.Unlock()
+		return 0, fmt.Errorf("%v: %q", unknownPartitionError, name)
+	}
+
+	var held []MessageI
+	for _, n := range healed {
+		held = append(held, p.partitions[n].held...)
+		delete(p.partitions, n)
+		p.logger.Info("network partition healed", zap.String("partition", n))
+	}
+	p.mu.Unlock()
+
+	// Decisions are made without the lock, since they lock semichannels.
+	sort.Slice(held, func(i, j int) bool { return held[i].GetSeqNum() < held[j].GetSeqNum() })
+	for _, msg := range held {
+		if release {
+			msg.Accept()
+		} else {
+			msg.Reject()
+		}
+	}
+	return len(held), nil
+}
+
+// Returns names of active partitions.
+func (p *Partitions) Names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.partitions))
+	for name := range p.partitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Partitions) Intercept(msg *Message) bool {
+	src, err := strconv.Atoi(msg.Src)
+	if err != nil {
+		return false
+	}
+	dst, err := strconv.Atoi(msg.Dst)
+	if err != nil {
+		return false
+	}
+
+	p.mu.Lock()
+	var name string
+	var part *partition
+	for n, candidate := range p.partitions {
+		for _, c := range candidate.cuts {
+			if c.from[src] && c.to[dst] {
+				name, part = n, candidate
+				break
+			}
+		}
+		if part != nil {
+			break
+		}
+	}
+	if part != nil && p.policy == PartitionHold {
+		part.held = append(part.held, msg)
+	}
+	p.mu.Unlock()
+
+	if part == nil {
+		return false
+	}
+	fields := append(fieldsFor(msg), zap.String("partition", name), zap.String("src", msg.Src), zap.String("dst", msg.Dst))
+	if p.policy == PartitionHold {
+		p.logger.Debug("Message held by partition", fields...)
+	} else {
+		p.logger.Debug("Message cut by partition", fields...)
+		msg.Reject()
+	}
+	return true
+}
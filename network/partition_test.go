@@ -0,0 +1,63 @@
+package network
+
+import (
+	"go.uber.org/zap"
+	"strconv"
+	"testing"
+)
+
+func newPartitionedMessage(sc *semichannel, counter *uint64, src int, dst int) *Message {
+	msg := newTestMessage(sc, counter, []byte{'x'})
+	msg.Src, msg.Dst = strconv.Itoa(src), strconv.Itoa(dst)
+	return msg
+}
+
+func TestPartitionsOneWay(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+	p := NewPartitions(PartitionReject, *zap.NewNop())
+	if err := p.Partition("p", [][]int{{1, 2}, {3}}, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !p.Intercept(newPartitionedMessage(sc, &counter, 1, 3)) {
+		t.Errorf("Message crossing the cut must be intercepted")
+	}
+	if p.Intercept(newPartitionedMessage(sc, &counter, 3, 2)) {
+		t.Errorf("Message against one-way cut must pass")
+	}
+	if p.Intercept(newPartitionedMessage(sc, &counter, 1, 2)) {
+		t.Errorf("Message within a group must pass")
+	}
+	if len(sc.readQueue) != 2 {
+		t.Errorf("intercepted Message must be rejected")
+	}
+}
+
+func TestPartitionsHoldAndHeal(t *testing.T) {
+	sc := &semichannel{}
+	counter := uint64(0)
+	p := NewPartitions(PartitionHold, *zap.NewNop())
+	if err := p.Partition("p", [][]int{{1}, {2}}, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := p.Partition("p", [][]int{{1}, {2}}, false); err == nil {
+		t.Errorf("duplicate partition must fail")
+	}
+
+	p.Intercept(newPartitionedMessage(sc, &counter, 2, 1))
+	p.Intercept(newPartitionedMessage(sc, &counter, 1, 2))
+	if sc.writes.len() != 0 || len(sc.readQueue) != 2 {
+		t.Fatalf("held messages must stay pending")
+	}
+
+	if n, err := p.Heal("", true); n != 2 || err != nil {
+		t.Fatalf("unexpected heal result: %v, %v", n, err)
+	}
+	if sc.writes.len() != 2 || len(p.Names()) != 0 {
+		t.Fatalf("held messages must be released on heal")
+	}
+	if _, err := p.Heal("p", true); err == nil {
+		t.Errorf("healing unknown partition must fail")
+	}
+}
@@ -0,0 +1,185 @@
+package network
+
+import (
+	"fmt"
+	"sync"
+)
+
+type OverflowPolicy int
+
+const (
+	// Reading from the connection stalls until a pending Message is decided on.
+	OverflowBlock OverflowPolicy = iota
+	// The oldest pending Message is rejected to make room for the new one.
+	OverflowDropOldest
+	// The new Message is rejected.
+	OverflowDropNewest
+	// The new Message is accepted without manual decision.
+	OverflowAccept
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDropNewest: "drop-newest",
+	OverflowAccept:     "auto-accept",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	if s == "" {
+		return OverflowBlock, nil
+	}
+	for policy, name := range overflowPolicyNames {
+		if name == s {
+			return policy, nil
+		}
+	}
+	return OverflowBlock, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// Messages of a channel awaiting manual decision. Readers put messages in without
+// waiting for anyone to take them out, so closed UI never stalls the proxy unless
+// the queue is full and the policy says so.
+type pendingQueue struct {
+	mu       sync.Mutex // protects everything below
+	capacity int        // zero stands for unbounded
+	policy   OverflowPolicy
+	// Undecided messages in arrival order.
+	msgs []*Message
+	// Messages not yet handed out, some of which may be decided already.
+	outbox []*Message
+	// Closed and replaced whenever a Message leaves the queue.
+	freed chan struct{}
+	// Signalled whenever outbox gets a Message.
+	ready chan struct{}
+}
+
+func newPendingQueue() *pendingQueue {
+	return &pendingQueue{
+		freed: make(chan struct{}),
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *pendingQueue) setLimit(capacity int, policy OverflowPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.capacity = capacity
+	q.policy = policy
+	// Blocked readers re-check the limit.
+	q.notifyFreed()
+}
+
+// Must be called with mu held.
+func (q *pendingQueue) notifyFreed() {
+	close(q.freed)
+	q.freed = make(chan struct{})
+}
+
+// Must be called with mu held.
+func (q *pendingQueue) full() bool {
+	return q.capacity > 0 && len(q.msgs) >= q.capacity
+}
+
+// Must be called with mu held.
+func (q *pendingQueue) push(msg *Message) {
+	q.msgs = append(q.msgs, msg)
+	q.outbox = append(q.outbox, msg)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Puts Message into the queue, applying overflow policy if the queue is full.
+// Returns whether Message was admitted, the Message evicted to make room for it
+// and the policy in effect; the caller decides on the messages left out. Blocking
+// policy waits until there is room or cancel is closed.
+func (q *pendingQueue) add(msg *Message, cancel <-chan struct{}) (admitted bool, evicted *Message, policy OverflowPolicy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.full() {
+		switch q.policy {
+		case OverflowDropOldest:
+			evicted = q.msgs[0]
+			q.msgs = q.msgs[1:]
+			q.push(msg)
+			return true, evicted, q.policy
+		case OverflowDropNewest, OverflowAccept:
+			return false, nil, q.policy
+		}
+		freed := q.freed
+		q.mu.Unlock()
+		select {
+		case <-freed:
+			q.mu.Lock()
+		case <-cancel:
+			q.mu.Lock()
+			return false, nil, OverflowBlock
+		}
+	}
+	q.push(msg)
+	return true, nil, q.policy
+}
+
+// Removes decided Message from the queue.
+func (q *pendingQueue) remove(seqnum uint64) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, msg := range q.msgs {
+		if msg.Seqnum == seqnum {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			q.notifyFreed()
+			return
+		}
+	}
+}
+
+// Takes the next undecided Message to be handed out, if any.
+func (q *pendingQueue) next() *Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.outbox) > 0 {
+		msg := q.outbox[0]
+		q.outbox = q.outbox[1:]
+		if q.contains(msg) {
+			return msg
+		}
+	}
+	return nil
+}
+
+// Must be called with mu held.
+func (q *pendingQueue) contains(msg *Message) bool {
+	for _, m := range q.msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *pendingQueue) snapshot() []MessageI {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs := make([]MessageI, len(q.msgs))
+	for i, msg := range q.msgs {
+		msgs[i] = msg
+	}
+	return msgs
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingQueueDropOldest(t *testing.T) {
+	q := newPendingQueue()
+	q.setLimit(2, OverflowDropOldest)
+	msgs := []*Message{{Seqnum: 1}, {Seqnum: 2}, {Seqnum: 3}}
+
+	for _, msg := range msgs[:2] {
+		if admitted, evicted, _ := q.add(msg, nil); !admitted || evicted != nil {
+			t.Fatalf("Message %v must be admitted without eviction", msg.Seqnum)
+		}
+	}
+	if admitted, evicted, _ := q.add(msgs[2], nil); !admitted || evicted != msgs[0] {
+		t.Fatalf("oldest Message must be evicted")
+	}
+	if order := seqNumsOf(q.snapshot()); len(order) != 2 || order[0] != 2 || order[1] != 3 {
+		t.Fatalf("unexpected pending messages: %v", order)
+	}
+	// Evicted Message is never handed out.
+	if msg := q.next(); msg != msgs[1] {
+		t.Fatalf("unexpected Message handed out: %v", msg)
+	}
+}
+
+func TestPendingQueueDropNewest(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowAccept} {
+		q := newPendingQueue()
+		q.setLimit(1, policy)
+		q.add(&Message{Seqnum: 1}, nil)
+		if admitted, _, applied := q.add(&Message{Seqnum: 2}, nil); admitted || applied != policy {
+			t.Errorf("%v: new Message must be left out", policy)
+		}
+		if n := len(q.snapshot()); n != 1 {
+			t.Errorf("%v: unexpected number of pending messages: %v", policy, n)
+		}
+	}
+}
+
+func TestPendingQueueBlock(t *testing.T) {
+	q := newPendingQueue()
+	q.setLimit(1, OverflowBlock)
+	q.add(&Message{Seqnum: 1}, nil)
+
+	done := make(chan bool)
+	go func() {
+		admitted, _, _ := q.add(&Message{Seqnum: 2}, nil)
+		done <- admitted
+	}()
+	select {
+	case <-done:
+		t.Fatalf("full queue must block")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.remove(1)
+	if !<-done {
+		t.Fatalf("Message must be admitted once there is room")
+	}
+
+	cancel := make(chan struct{})
+	go func() {
+		admitted, _, _ := q.add(&Message{Seqnum: 3}, cancel)
+		done <- admitted
+	}()
+	close(cancel)
+	if <-done {
+		t.Fatalf("cancelled Message must not be admitted")
+	}
+}
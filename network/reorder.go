@@ -0,0 +1,136 @@
+package network
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type ReorderMode int
+
+const (
+	// Accepted messages leave in acceptance order.
+	ReorderNone ReorderMode = iota
+	// Held messages are released in random order.
+	ReorderRandom
+	// Held messages are released last accepted first.
+	ReorderLIFO
+	// Held messages are released only in the order given explicitly.
+	ReorderExplicit
+)
+
+var reorderModeNames = map[ReorderMode]string{
+	ReorderNone:     "none",
+	ReorderRandom:   "random",
+	ReorderLIFO:     "lifo",
+	ReorderExplicit: "explicit",
+}
+
+func (m ReorderMode) String() string {
+	if name, ok := reorderModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("ReorderMode(%d)", int(m))
+}
+
+func ParseReorderMode(s string) (ReorderMode, error) {
+	if s == "" {
+		return ReorderNone, nil
+	}
+	for mode, name := range reorderModeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return ReorderNone, fmt.Errorf("unknown reorder mode %q", s)
+}
+
+// Configuration of the hold-back buffer of a channel.
+type Reorder struct {
+	Mode ReorderMode
+	// Number of held messages triggering release; ignored in explicit mode.
+	Window int
+	// Held messages are released once the oldest of them waits for this long;
+	// zero disables the timeout. Ignored in explicit mode.
+	Timeout time.Duration
+	Seed    int64
+}
+
+// Holds accepted messages back and releases them permuted.
+type holdBuffer struct {
+	config Reorder
+	rnd    *rand.Rand
+	held   []MessageI
+	// Cancels pending release on timeout, if any.
+	cancelTimer func()
+}
+
+func newHoldBuffer(config Reorder) *holdBuffer {
+	if config.Window < 1 {
+		config.Window = 1
+	}
+	return &holdBuffer{
+		config: config,
+		rnd:    rand.New(rand.NewSource(config.Seed)),
+		held:   make([]MessageI, 0),
+	}
+}
+
+// Holds Message back; returns messages to be released immediately, if any.
+func (h *holdBuffer) add(msg MessageI) []MessageI {
+	h.held = append(h.held, msg)
+	if h.config.Mode != ReorderExplicit && len(h.held) >= h.config.Window {
+		return h.flush()
+	}
+	return nil
+}
+
+// Releases all held messages in the order chosen by the mode.
+func (h *holdBuffer) flush() []MessageI {
+	out := h.held
+	h.held = make([]MessageI, 0)
+	h.stopTimer()
+
+	switch h.config.Mode {
+	case ReorderRandom:
+		h.rnd.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	case ReorderLIFO:
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out
+}
+
+// Releases held messages listed in order; unknown sequence numbers are skipped.
+func (h *holdBuffer) take(order []uint64) []MessageI {
+	out := make([]MessageI, 0, len(order))
+	for _, seqnum := range order {
+		for i, msg := range h.held {
+			if msg.GetSeqNum() == seqnum {
+				out = append(out, msg)
+				h.held = append(h.held[:i], h.held[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(h.held) == 0 {
+		h.stopTimer()
+	}
+	return out
+}
+
+func (h *holdBuffer) stopTimer() {
+	if h.cancelTimer != nil {
+		h.cancelTimer()
+		h.cancelTimer = nil
+	}
+}
+
+func seqNumsOf(msgs []MessageI) []uint64 {
+	seqnums := make([]uint64, len(msgs))
+	for i, msg := range msgs {
+		seqnums[i] = msg.GetSeqNum()
+	}
+	return seqnums
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func heldMessages(seqnums ...uint64) []MessageI {
+	msgs := make([]MessageI, len(seqnums))
+	for i, seqnum := range seqnums {
+		msgs[i] = &Message{Seqnum: seqnum}
+	}
+	return msgs
+}
+
+func TestHoldBufferLIFO(t *testing.T) {
+	h := newHoldBuffer(Reorder{Mode: ReorderLIFO, Window: 3})
+	var released []MessageI
+	for _, msg := range heldMessages(1, 2, 3) {
+		released = h.add(msg)
+	}
+	if actual, expected := seqNumsOf(released), []uint64{3, 2, 1}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unmatched order: actual %v, expected %v", actual, expected)
+	}
+}
+
+func TestHoldBufferRandomSeeded(t *testing.T) {
+	permute := func() []uint64 {
+		h := newHoldBuffer(Reorder{Mode: ReorderRandom, Window: 10, Seed: 7})
+		for _, msg := range heldMessages(1, 2, 3, 4, 5, 6, 7, 8, 9) {
+			h.add(msg)
+		}
+		return seqNumsOf(h.flush())
+	}
+	if a, b := permute(), permute(); !reflect.DeepEqual(a, b) {
+		t.Fatalf("unmatched permutations: %v vs %v", a, b)
+	}
+}
+
+func TestHoldBufferExplicit(t *testing.T) {
+	h := newHoldBuffer(Reorder{Mode: ReorderExplicit, Window: 1})
+	for _, msg := range heldMessages(1, 2, 3) {
+		if released := h.add(msg); released != nil {
+			t.Fatalf("unexpected release in explicit mode: %v", seqNumsOf(released))
+		}
+	}
+	if actual, expected := seqNumsOf(h.take([]uint64{3, 5, 1})), []uint64{3, 1}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unmatched order: actual %v, expected %v", actual, expected)
+	}
+	if actual, expected := seqNumsOf(h.held), []uint64{2}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unmatched held messages: actual %v, expected %v", actual, expected)
+	}
+}
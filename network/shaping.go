@@ -0,0 +1,82 @@
+package network
+
+import (
+	"time"
+)
+
+// Shaping of the traffic written by a channel. Every frame is written once
+// frame latency plus byte latency per byte of the frame elapse since it was
+// accepted, as if it travelled a long link, so frames in flight overlap and
+// only bandwidth limits throughput.
+type Shaping struct {
+	// Bytes per second; zero disables bandwidth limit.
+	Bandwidth int64
+	// Capacity of the token bucket in bytes; defaults to one second worth of bandwidth.
+	Burst        int64
+	FrameLatency time.Duration
+	ByteLatency  time.Duration
+}
+
+func (s Shaping) enabled() bool {
+	return s.Bandwidth > 0 || s.FrameLatency > 0 || s.ByteLatency > 0
+}
+
+// Token bucket holding bytes allowed to be written.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64, burst int64, now time.Time) *tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// Takes n tokens; returns how long to wait before n bytes may be written.
+// Frames exceeding the burst are let through once the debt is repaid.
+func (b *tokenBucket) take(n int, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Applies Shaping to frames of a single semichannel.
+type shaper struct {
+	config Shaping
+	bucket *tokenBucket
+}
+
+func newShaper(config Shaping) *shaper {
+	s := &shaper{config: config}
+	if config.Bandwidth > 0 {
+		s.bucket = newTokenBucket(config.Bandwidth, config.Burst, time.Now())
+	}
+	return s
+}
+
+// Returns delay of the next frame of the given size, enqueued at the given time.
+func (s *shaper) delay(size int, enqueued time.Time, now time.Time) time.Duration {
+	arrival := enqueued.Add(s.config.FrameLatency + time.Duration(size)*s.config.ByteLatency)
+	if arrival.Before(now) {
+		arrival = now
+	}
+	d := arrival.Sub(now)
+	if s.bucket != nil {
+		// Bandwidth is consumed once the frame arrives.
+		d += s.bucket.take(size, arrival)
+	}
+	return d
+}
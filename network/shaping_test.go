@@ -0,0 +1,52 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(1000, 500, now)
+
+	if d := b.take(500, now); d != 0 {
+		t.Errorf("burst must pass without delay, got %v", d)
+	}
+	if d := b.take(100, now); d != 100*time.Millisecond {
+		t.Errorf("unexpected delay of exhausted bucket: %v", d)
+	}
+	// Debt of 100 bytes is repaid after 100ms, and the next 100 bytes take another 100ms.
+	if d := b.take(200, now.Add(200*time.Millisecond)); d != 100*time.Millisecond {
+		t.Errorf("unexpected delay after refill: %v", d)
+	}
+	if d := b.take(0, now.Add(time.Hour)); d != 0 || b.tokens != b.burst {
+		t.Errorf("bucket must refill up to burst, got %v tokens", b.tokens)
+	}
+}
+
+func TestShaperLatency(t *testing.T) {
+	s := newShaper(Shaping{FrameLatency: time.Millisecond, ByteLatency: time.Microsecond})
+	now := time.Now()
+	if d := s.delay(1000, now, now); d != 2*time.Millisecond {
+		t.Errorf("unexpected delay: %v", d)
+	}
+	if d := s.delay(1000, now.Add(-time.Millisecond), now); d != time.Millisecond {
+		t.Errorf("latency must count since enqueueing, got %v", d)
+	}
+}
+
+func TestShaperLatencyPipelines(t *testing.T) {
+	s := newShaper(Shaping{FrameLatency: 100 * time.Millisecond, Bandwidth: 1000})
+	enqueued := time.Now()
+	if d := s.delay(100, enqueued, enqueued); d != 100*time.Millisecond {
+		t.Errorf("unexpected delay of the first frame: %v", d)
+	}
+	// Frames accepted together arrive together rather than one latency apart.
+	if d := s.delay(100, enqueued, enqueued.Add(100*time.Millisecond)); d != 0 {
+		t.Errorf("unexpected delay of the second frame: %v", d)
+	}
+	// Bandwidth still limits throughput once the burst is spent.
+	if d := s.delay(900, enqueued, enqueued.Add(100*time.Millisecond)); d != 100*time.Millisecond {
+		t.Errorf("unexpected delay of the third frame: %v", d)
+	}
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Set of timers owned by a channel. Closing the set cancels pending timers
+// and waits for the running ones, so no scheduled function outlives the channel.
+type timerSet struct {
+	mu      sync.Mutex // protects closed and pending
+	closed  bool
+	pending map[*time.Timer]struct{}
+	running sync.WaitGroup
+}
+
+func newTimerSet() *timerSet {
+	return &timerSet{pending: make(map[*time.Timer]struct{})}
+}
+
+// Schedules fn to run at deadline; past deadlines fire immediately.
+// Returns function cancelling the timer, or nil if the set is closed.
+// Nil set falls back to an unmanaged timer.
+func (ts *timerSet) at(deadline time.Time, fn func()) func() {
+	if ts == nil {
+		t := time.AfterFunc(time.Until(deadline), fn)
+		return func() { t.Stop() }
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.closed {
+		return nil
+	}
+	var t *time.Timer
+	t = time.AfterFunc(time.Until(deadline), func() {
+		ts.mu.Lock()
+		if _, ok := ts.pending[t]; !ok {
+			ts.mu.Unlock()
+			return
+		}
+		delete(ts.pending, t)
+		ts.running.Add(1)
+		ts.mu.Unlock()
+
+		defer ts.running.Done()
+		fn()
+	})
+	ts.pending[t] = struct{}{}
+	return func() { ts.cancel(t) }
+}
+
+func (ts *timerSet) after(d time.Duration, fn func()) func() {
+	return ts.at(time.Now().Add(d), fn)
+}
+
+func (ts *timerSet) cancel(t *time.Timer) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.pending[t]; ok {
+		delete(ts.pending, t)
+		t.Stop()
+	}
+}
+
+// Cancels pending timers and waits for the running ones to complete.
+func (ts *timerSet) close() {
+	ts.mu.Lock()
+	ts.closed = true
+	for t := range ts.pending {
+		t.Stop()
+	}
+	ts.pending = make(map[*time.Timer]struct{})
+	ts.mu.Unlock()
+
+	ts.running.Wait()
+}
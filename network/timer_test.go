@@ -0,0 +1,64 @@
+package network
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimerSetClose(t *testing.T) {
+	ts := newTimerSet()
+	fired := int32(0)
+
+	ts.after(0, func() { atomic.AddInt32(&fired, 1) })
+	ts.after(time.Hour, func() { atomic.AddInt32(&fired, 1) })
+	cancel := ts.after(time.Millisecond, func() { atomic.AddInt32(&fired, 1) })
+	cancel()
+
+	time.Sleep(10 * time.Millisecond)
+	ts.close()
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Fatalf("unexpected number of fired timers: %v", n)
+	}
+	if cancel := ts.after(0, func() { t.Errorf("timer fired after close") }); cancel != nil {
+		t.Errorf("closed set must not schedule timers")
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestMessageAcceptAt(t *testing.T) {
+	sc := &semichannel{timers: newTimerSet()}
+	counter := uint64(0)
+	msg := newTestMessage(sc, &counter, []byte{'x'})
+
+	deadline := time.Now().Add(20 * time.Millisecond)
+	msg.AcceptAt(deadline)
+	select {
+	case <-sc.writes.wait():
+		if time.Now().Before(deadline) {
+			t.Fatalf("Message accepted before deadline")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Message was not accepted")
+	}
+	sc.timers.close()
+}
+
+func TestMessageAcceptAtClosedChannel(t *testing.T) {
+	sc := &semichannel{timers: newTimerSet()}
+	sc.timers.close()
+	core, logs := zapobserver.New(zapcore.WarnLevel)
+	counter := uint64(0)
+	msg := sc.receive([]byte{'x'}, "", "", &counter, *zap.New(core))
+
+	msg.AcceptAfter(0)
+	if logs.Len() != 1 {
+		t.Errorf("ignored acceptance must be logged, got %v", logs.All())
+	}
+	if len(sc.readQueue) != 1 || sc.writes.len() != 0 {
+		t.Errorf("Message must stay pending")
+	}
+}
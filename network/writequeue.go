@@ -0,0 +1,82 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// Accepted Message along with the time it was enqueued for writing.
+type queuedMessage struct {
+	msg MessageI
+	at  time.Time
+}
+
+// Accepted messages of a semichannel awaiting write, in acceptance order. Putting
+// never blocks, so decisions may be taken with semichannel locks held while the
+// writer is stuck on a slow or stopped destination. Zero value is an empty queue.
+type writeQueue struct {
+	mu    sync.Mutex // protects everything below
+	msgs  []queuedMessage
+	ready chan struct{} // signalled whenever the writer has something to check
+}
+
+// Must be called with mu held.
+func (q *writeQueue) readyCh() chan struct{} {
+	if q.ready == nil {
+		q.ready = make(chan struct{}, 1)
+	}
+	return q.ready
+}
+
+// Must be called with mu held.
+func (q *writeQueue) notify() {
+	select {
+	case q.readyCh() <- struct{}{}:
+	default:
+	}
+}
+
+func (q *writeQueue) put(msg MessageI, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.msgs = append(q.msgs, queuedMessage{msg: msg, at: at})
+	q.notify()
+}
+
+// Wakes the writer up to check the flow.
+func (q *writeQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.notify()
+}
+
+// Takes the oldest Message, if any.
+func (q *writeQueue) take() (queuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.msgs) == 0 {
+		return queuedMessage{}, false
+	}
+	queued := q.msgs[0]
+	q.msgs[0] = queuedMessage{}
+	q.msgs = q.msgs[1:]
+	return queued, true
+}
+
+// Returns channel signalled once there may be something to take.
+func (q *writeQueue) wait() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.readyCh()
+}
+
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.msgs)
+}
@@ -0,0 +1,376 @@
+package replay
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"hse-dss-efimov/journal"
+	"hse-dss-efimov/network"
+	"sync"
+	"time"
+)
+
+// Point where the live run stopped following the recorded one.
+type Divergence struct {
+	// Number of recorded decisions applied before the divergence.
+	Step    int
+	Channel string
+	Src     string
+	// Arrival index of the offending Message within its flow.
+	Index  int
+	Reason string
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("divergence after %d steps at message #%d from %v on channel %v: %v",
+		d.Step, d.Index, d.Src, d.Channel, d.Reason)
+}
+
+// Direction of a channel; arrivals are counted separately for each direction,
+// since messages travelling opposite ways race with each other.
+type flow struct {
+	channel string
+	src     string
+}
+
+type arrival struct {
+	seqnum uint64
+	crc64  uint64
+}
+
+// Recorded decision.
+type step struct {
+	seqnum   uint64
+	channel  string
+	src      string
+	copies   int
+	payload  []byte // replacement payload, or payload of injected Message
+	modified bool
+	injected bool
+}
+
+// Decides on live messages the way they were decided on in a recorded run.
+// Live messages are matched to recorded ones by channel, arrival index within
+// the direction of the channel and Crc64; decisions are applied in recorded
+// order, each one waiting for its Message to arrive.
+type Replayer struct {
+	logger zap.Logger
+	// Lenient replayer accepts messages no decision is recorded for and skips
+	// decisions whose messages do not arrive, rather than diverging.
+	lenient bool
+	// Minimum interval between decisions, letting decided messages reach their destinations.
+	pace time.Duration
+
+	mu       sync.Mutex // protects everything below
+	steps    []step
+	next     int
+	arrivals map[flow][]arrival
+	seen     map[flow]int
+	live     map[uint64]network.MessageI // by recorded Seqnum
+	// Recorded Seqnums of messages decided on by the steps to replay.
+	decided    map[uint64]bool
+	channels   map[string]network.Channel
+	divergence *Divergence
+	skipped    int
+	decidedAt  time.Time
+	paused     bool          // set while waiting for the pace interval to elapse
+	doneCh     chan struct{} // closed once replay completes or diverges
+	progressCh chan struct{}
+}
+
+func NewReplayer(records []journal.Record, channels []network.Channel, logger zap.Logger) *Replayer {
+	r := &Replayer{
+		logger:     logger,
+		arrivals:   make(map[flow][]arrival),
+		seen:       make(map[flow]int),
+		live:       make(map[uint64]network.MessageI),
+		decided:    make(map[uint64]bool),
+		channels:   make(map[string]network.Channel),
+		doneCh:     make(chan struct{}),
+		progressCh: make(chan struct{}, 1),
+	}
+	for _, channel := range channels {
+		r.channels[channel.GetName()] = channel
+	}
+
+	injected := make(map[uint64][]byte)
+	modified := make(map[uint64][]byte)
+	for _, record := range records {
+		// Duplicates are produced by decisions on their originals.
+		if record.Origin != 0 {
+			continue
+		}
+		switch record.Kind {
+		case journal.KindReceive:
+			if record.Injected {
+				injected[record.Seqnum] = record.Payload
+			} else {
+				f := flow{record.Channel, record.Src}
+				r.arrivals[f] = append(r.arrivals[f], arrival{record.Seqnum, record.Crc64})
+			}
+		case journal.KindModify:
+			modified[record.Seqnum] = record.Payload
+		case journal.KindDecide:
+			s := step{seqnum: record.Seqnum, channel: record.Channel, src: record.Src, copies: record.Copies}
+			if payload, ok := injected[record.Seqnum]; ok {
+				s.injected, s.payload = true, payload
+			} else if payload, ok := modified[record.Seqnum]; ok {
+				s.modified, s.payload = true, payload
+			}
+			r.steps = append(r.steps, s)
+			r.decided[s.seqnum] = true
+		}
+	}
+	return r
+}
+
+// Returns number of recorded decisions to replay.
+func (r *Replayer) Steps() int {
+	return len(r.steps)
+}
+
+// Replays only the given number of leading decisions. Messages decided on later
+// in the recorded run are not intercepted, so that they can be decided on by
+// other means. Must be called before Start.
+func (r *Replayer) StopAfter(steps int) error {
+	if steps < 0 || steps > len(r.steps) {
+		return fmt.Errorf("stop point %d lies outside of %d recorded steps", steps, len(r.steps))
+	}
+	r.steps = r.steps[:steps]
+	r.decided = make(map[uint64]bool)
+	for _, s := range r.steps {
+		r.decided[s.seqnum] = true
+	}
+	return nil
+}
+
+// Makes replayer lenient. Must be called before Start.
+func (r *Replayer) SetLenient() {
+	r.lenient = true
+}
+
+// Makes replayer wait at least pace between consecutive decisions. Without it
+// messages decided on one after another race to their destinations through
+// different channels. Must be called before Start.
+func (r *Replayer) SetPace(pace time.Duration) {
+	r.pace = pace
+}
+
+// Returns number of decisions skipped by lenient replayer.
+func (r *Replayer) Skipped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.skipped
+}
+
+// Returns number of leading decisions up to and including the one on the Message
+// with the given recorded Seqnum.
+func (r *Replayer) StepOf(seqnum uint64) (int, bool) {
+	for i, s := range r.steps {
+		if s.seqnum == seqnum {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// Applies leading decisions which do not wait for any Message, such as injections.
+// Must be called once the replayer intercepts messages of the channels.
+func (r *Replayer) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.steps) == 0 {
+		r.complete()
+		return
+	}
+	r.advance()
+}
+
+// Must be called with mu held.
+func (r *Replayer) finished() bool {
+	return r.divergence != nil || r.next == len(r.steps)
+}
+
+// Must be called with mu held.
+func (r *Replayer) diverge(d *Divergence) {
+	d.Step = r.next
+	r.divergence = d
+	r.logger.Error("replay diverged", zap.Error(d))
+	close(r.doneCh)
+}
+
+// Must be called with mu held.
+func (r *Replayer) complete() {
+	r.logger.Info("replay complete", zap.Int("steps", r.next))
+	close(r.doneCh)
+}
+
+// Applies recorded decisions in order as long as their messages have arrived.
+// Must be called with mu held.
+func (r *Replayer) advance() {
+	if r.finished() {
+		return
+	}
+	for r.next < len(r.steps) {
+		if wait := r.pace - time.Since(r.decidedAt); r.next > 0 && wait > 0 {
+			if !r.paused {
+				r.paused = true
+				time.AfterFunc(wait, r.resume)
+			}
+			return
+		}
+		s := r.steps[r.next]
+		if s.injected {
+			channel, ok := r.channels[s.channel]
+			if !ok {
+				r.diverge(&Divergence{Channel: s.channel, Src: s.src, Reason: "unknown channel"})
+				return
+			}
+			if _, err := channel.Inject(s.payload); err != nil {
+				r.diverge(&Divergence{Channel: s.channel, Src: s.src, Reason: err.Error()})
+				return
+			}
+		} else {
+			msg, ok := r.live[s.seqnum]
+			if !ok {
+				return
+			}
+			delete(r.live, s.seqnum)
+			if s.modified {
+				if err := msg.Modify(s.payload); err != nil {
+					r.diverge(&Divergence{Channel: s.channel, Src: s.src, Index: r.arrivalIndex(s), Reason: err.Error()})
+					return
+				}
+			}
+			switch {
+			case s.copies == 0:
+				msg.Reject()
+			case s.copies == 1:
+				msg.Accept()
+			default:
+				msg.Duplicate(s.copies - 1)
+			}
+		}
+		r.next++
+		r.decidedAt = time.Now()
+		select {
+		case r.progressCh <- struct{}{}:
+		default:
+		}
+	}
+	r.complete()
+}
+
+func (r *Replayer) resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = false
+	r.advance()
+}
+
+func (r *Replayer) Intercept(msg *network.Message) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.finished() {
+		return r.acceptLeniently(msg)
+	}
+	f := flow{msg.Channel, msg.Src}
+	index := r.seen[f]
+	r.seen[f]++
+	recorded := r.arrivals[f]
+	if index >= len(recorded) {
+		if r.lenient {
+			return r.acceptLeniently(msg)
+		}
+		r.diverge(&Divergence{Channel: f.channel, Src: f.src, Index: index, Reason: "unexpected message"})
+		return false
+	}
+	if recorded[index].crc64 != msg.Crc64 {
+		if r.lenient {
+			return r.acceptLeniently(msg)
+		}
+		r.diverge(&Divergence{Channel: f.channel, Src: f.src, Index: index,
+			Reason: fmt.Sprintf("Crc64 %v differs from recorded %v", msg.Crc64, recorded[index].crc64)})
+		return false
+	}
+	if !r.decided[recorded[index].seqnum] {
+		return r.acceptLeniently(msg)
+	}
+	r.live[recorded[index].seqnum] = msg
+	r.advance()
+	return true
+}
+
+// Accepts Message if replayer is lenient; leaves it to others otherwise.
+func (r *Replayer) acceptLeniently(msg *network.Message) bool {
+	if !r.lenient {
+		return false
+	}
+	msg.Accept()
+	return true
+}
+
+// Waits for replay to complete; returns Divergence if the live run stops following
+// the recorded one, including the case of no progress within idle timeout.
+// Lenient replayer skips the decision it waits for on idle timeout instead.
+func (r *Replayer) Wait(idle time.Duration) error {
+	deadline := time.Now().Add(idle)
+	for {
+		select {
+		case <-r.doneCh:
+			return r.err()
+		case <-r.progressCh:
+			deadline = time.Now().Add(idle)
+		case <-time.After(time.Until(deadline)):
+			r.mu.Lock()
+			if r.lenient && !r.finished() {
+				s := r.steps[r.next]
+				r.logger.Warn("skipping decision on message which did not arrive",
+					zap.Int("step", r.next), zap.String("channel", s.channel), zap.Int("index", r.arrivalIndex(s)))
+				r.skipped++
+				// Should the Message arrive after all, it is accepted.
+				delete(r.decided, s.seqnum)
+				r.next++
+				if r.next == len(r.steps) {
+					r.complete()
+				} else {
+					r.advance()
+				}
+				r.mu.Unlock()
+				deadline = time.Now().Add(idle)
+				continue
+			}
+			if !r.finished() {
+				s := r.steps[r.next]
+				r.diverge(&Divergence{Channel: s.channel, Src: s.src, Index: r.arrivalIndex(s),
+					Reason: fmt.Sprintf("recorded message did not arrive within %v", idle)})
+			}
+			r.mu.Unlock()
+			return r.err()
+		}
+	}
+}
+
+// Returns arrival index of the Message decided on at the given step.
+// Must be called with mu held.
+func (r *Replayer) arrivalIndex(s step) int {
+	for i, a := range r.arrivals[flow{s.channel, s.src}] {
+		if a.seqnum == s.seqnum {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Replayer) err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.divergence != nil {
+		return r.divergence
+	}
+	return nil
+}
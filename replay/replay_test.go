@@ -0,0 +1,240 @@
+package replay
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"hse-dss-efimov/journal"
+	"hse-dss-efimov/network"
+	"testing"
+	"time"
+)
+
+type fakeChannel struct {
+	network.Channel
+	name     string
+	injected [][]byte
+}
+
+func (c *fakeChannel) GetName() string {
+	return c.name
+}
+
+func (c *fakeChannel) Inject(payload []byte) (network.MessageI, error) {
+	c.injected = append(c.injected, payload)
+	return nil, nil
+}
+
+func receive(seqnum uint64, src string, crc64 uint64) journal.Record {
+	return journal.Record{Kind: journal.KindReceive, Channel: "1-2", Seqnum: seqnum, Src: src, Crc64: crc64}
+}
+
+func decide(seqnum uint64, src string, copies int) journal.Record {
+	return journal.Record{Kind: journal.KindDecide, Channel: "1-2", Seqnum: seqnum, Src: src, Copies: copies}
+}
+
+// Returns live Message appending its decisions to decisions.
+func liveMessage(src string, crc64 uint64, decisions *[]int) *network.Message {
+	return &network.Message{Channel: "1-2", Src: src, Crc64: crc64, DecideFn: func(copies int) {
+		*decisions = append(*decisions, copies)
+	}}
+}
+
+func TestReplayAppliesDecisionsInRecordedOrder(t *testing.T) {
+	records := []journal.Record{
+		receive(1, "1", 10),
+		receive(2, "2", 20),
+		decide(2, "2", 0),
+		decide(1, "1", 2),
+	}
+	r := NewReplayer(records, nil, *zap.NewNop())
+	r.Start()
+
+	var first, second []int
+	if !r.Intercept(liveMessage("1", 10, &first)) {
+		t.Fatal("recorded message was not intercepted")
+	}
+	if len(first) != 0 {
+		t.Fatalf("decision applied ahead of recorded order: %v", first)
+	}
+	if !r.Intercept(liveMessage("2", 20, &second)) {
+		t.Fatal("recorded message was not intercepted")
+	}
+	if len(second) != 1 || second[0] != 0 || len(first) != 1 || first[0] != 2 {
+		t.Fatalf("unexpected decisions: %v, %v", first, second)
+	}
+	if err := r.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayInjects(t *testing.T) {
+	injected := receive(1, "", 10)
+	injected.Injected = true
+	injected.Payload = []byte("forged")
+	channel := &fakeChannel{name: "1-2"}
+	r := NewReplayer([]journal.Record{injected, decide(1, "", 1)}, []network.Channel{channel}, *zap.NewNop())
+	r.Start()
+
+	if err := r.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(channel.injected) != 1 || string(channel.injected[0]) != "forged" {
+		t.Errorf("unexpected injections: %q", channel.injected)
+	}
+}
+
+func TestReplayDiverges(t *testing.T) {
+	records := []journal.Record{receive(1, "1", 10), decide(1, "1", 1)}
+	tests := []struct {
+		name   string
+		src    string
+		crc64  uint64
+		index  int
+		reason string
+	}{
+		{"crc", "1", 11, 0, "Crc64 11 differs from recorded 10"},
+		{"unexpected", "2", 10, 0, "unexpected message"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReplayer(records, nil, *zap.NewNop())
+			r.Start()
+			var decisions []int
+			if r.Intercept(liveMessage(test.src, test.crc64, &decisions)) {
+				t.Fatal("diverging message was intercepted")
+			}
+			var d *Divergence
+			if err := r.Wait(time.Second); !errors.As(err, &d) {
+				t.Fatalf("expected divergence, got %v", err)
+			}
+			if d.Index != test.index || d.Reason != test.reason || d.Step != 0 {
+				t.Errorf("unexpected divergence: %+v", d)
+			}
+			if r.Intercept(liveMessage("1", 10, &decisions)) || len(decisions) != 0 {
+				t.Error("replay went on after divergence")
+			}
+		})
+	}
+}
+
+func TestReplayIdleTimeout(t *testing.T) {
+	records := []journal.Record{receive(1, "1", 10), receive(2, "1", 20), decide(2, "1", 1)}
+	r := NewReplayer(records, nil, *zap.NewNop())
+	r.Start()
+
+	var d *Divergence
+	if err := r.Wait(10 * time.Millisecond); !errors.As(err, &d) {
+		t.Fatalf("expected divergence, got %v", err)
+	}
+	if d.Index != 1 || d.Src != "1" {
+		t.Errorf("unexpected divergence: %+v", d)
+	}
+}
+
+func TestReplayEmptyJournal(t *testing.T) {
+	r := NewReplayer(nil, nil, *zap.NewNop())
+	r.Start()
+	if err := r.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayStopsAfterPrefix(t *testing.T) {
+	records := []journal.Record{
+		receive(1, "1", 10),
+		receive(2, "1", 20),
+		decide(2, "1", 1),
+		decide(1, "1", 0),
+	}
+	r := NewReplayer(records, nil, *zap.NewNop())
+	steps, ok := r.StepOf(2)
+	if !ok || steps != 1 {
+		t.Fatalf("unexpected step of Seqnum 2: %v, %v", steps, ok)
+	}
+	if err := r.StopAfter(steps); err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+
+	var first, second []int
+	if r.Intercept(liveMessage("1", 10, &first)) {
+		t.Error("message decided on after the stop point was intercepted")
+	}
+	if !r.Intercept(liveMessage("1", 20, &second)) {
+		t.Error("message decided on before the stop point was not intercepted")
+	}
+	if err := r.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 0 || len(second) != 1 || second[0] != 1 {
+		t.Errorf("unexpected decisions: %v, %v", first, second)
+	}
+	if r.Intercept(liveMessage("1", 30, &first)) {
+		t.Error("message was intercepted after the stop point")
+	}
+}
+
+func TestReplayStopOutOfRange(t *testing.T) {
+	r := NewReplayer([]journal.Record{receive(1, "1", 10), decide(1, "1", 1)}, nil, *zap.NewNop())
+	if err := r.StopAfter(2); err == nil {
+		t.Error("stop point beyond recorded steps was accepted")
+	}
+	if _, ok := r.StepOf(3); ok {
+		t.Error("step of unknown Seqnum was found")
+	}
+}
+
+func TestReplayLenient(t *testing.T) {
+	records := []journal.Record{
+		receive(1, "1", 10),
+		receive(2, "1", 20),
+		receive(3, "2", 30),
+		decide(3, "2", 0),
+		decide(2, "1", 2),
+	}
+	r := NewReplayer(records, nil, *zap.NewNop())
+	r.SetLenient()
+	r.Start()
+
+	var unrecorded, undecided, late, held []int
+	if !r.Intercept(liveMessage("1", 10, &undecided)) || len(undecided) != 1 || undecided[0] != 1 {
+		t.Errorf("message without recorded decision was not accepted: %v", undecided)
+	}
+	if !r.Intercept(liveMessage("1", 20, &held)) || len(held) != 0 {
+		t.Errorf("message was decided ahead of recorded order: %v", held)
+	}
+	if !r.Intercept(liveMessage("3", 40, &unrecorded)) || len(unrecorded) != 1 || unrecorded[0] != 1 {
+		t.Errorf("unrecorded message was not accepted: %v", unrecorded)
+	}
+	// Decision on the Message from 2 is skipped, since the Message does not arrive in time.
+	if err := r.Wait(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if r.Skipped() != 1 || len(held) != 1 || held[0] != 2 {
+		t.Errorf("unexpected outcome: %v skipped, decisions %v", r.Skipped(), held)
+	}
+	if !r.Intercept(liveMessage("2", 30, &late)) || len(late) != 1 || late[0] != 1 {
+		t.Errorf("late message was not accepted: %v", late)
+	}
+}
+
+func TestReplayPace(t *testing.T) {
+	records := []journal.Record{receive(1, "1", 10), receive(2, "1", 20), decide(1, "1", 1), decide(2, "1", 1)}
+	r := NewReplayer(records, nil, *zap.NewNop())
+	r.SetPace(50 * time.Millisecond)
+	r.Start()
+
+	var first, second []int
+	start := time.Now()
+	r.Intercept(liveMessage("1", 10, &first))
+	r.Intercept(liveMessage("1", 20, &second))
+	if err := r.Wait(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("decisions were %v apart", elapsed)
+	}
+	if len(first) != 1 || len(second) != 1 {
+		t.Errorf("unexpected decisions: %v, %v", first, second)
+	}
+}
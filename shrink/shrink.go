@@ -0,0 +1,126 @@
+package shrink
+
+import (
+	"hse-dss-efimov/journal"
+)
+
+// Returns recorded Seqnums of messages decided on in the journal, in order of decisions.
+// Every such decision constrains a replay: it drops, duplicates, modifies or injects
+// its Message, or holds it back until the decisions recorded ahead of it are made.
+func Decisions(records []journal.Record) []uint64 {
+	var decisions []uint64
+	for _, record := range records {
+		// Duplicates are produced by decisions on their originals.
+		if record.Kind == journal.KindDecide && record.Origin == 0 {
+			decisions = append(decisions, record.Seqnum)
+		}
+	}
+	return decisions
+}
+
+// Returns journal records with decisions on messages other than kept ones removed,
+// along with modifications of those messages. Receptions are retained, so that
+// arrival indices of messages stay as recorded.
+func Keep(records []journal.Record, kept []uint64) []journal.Record {
+	keep := make(map[uint64]bool)
+	for _, seqnum := range kept {
+		keep[seqnum] = true
+	}
+	var result []journal.Record
+	for _, record := range records {
+		if (record.Kind == journal.KindDecide || record.Kind == journal.KindModify) && record.Origin == 0 &&
+			!keep[record.Seqnum] {
+			continue
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+// Delta debugging: reduces decisions to a subset for which fails still holds and
+// which has no single decision that can be removed, assuming fails holds for all
+// decisions. Subsets are tested in order of decisions; fails is called at most once
+// for each distinct subset.
+func Minimize(decisions []uint64, fails func(kept []uint64) bool) []uint64 {
+	tested := make(map[string]bool)
+	test := func(kept []uint64) bool {
+		key := string(encode(kept))
+		if result, ok := tested[key]; ok {
+			return result
+		}
+		result := fails(kept)
+		tested[key] = result
+		return result
+	}
+
+	if test(nil) {
+		return nil
+	}
+	current := decisions
+	granularity := 2
+	for len(current) >= 2 {
+		subsets := split(current, granularity)
+		reduced := false
+		for _, subset := range subsets {
+			if test(subset) {
+				current, granularity, reduced = subset, 2, true
+				break
+			}
+		}
+		if !reduced {
+			for i := range subsets {
+				complement := complementOf(subsets, i)
+				if test(complement) {
+					current, reduced = complement, true
+					if granularity > 2 {
+						granularity--
+					}
+					break
+				}
+			}
+		}
+		if !reduced {
+			if granularity >= len(current) {
+				break
+			}
+			granularity *= 2
+			if granularity > len(current) {
+				granularity = len(current)
+			}
+		}
+	}
+	return current
+}
+
+// Splits decisions into n contiguous subsets of nearly equal size.
+func split(decisions []uint64, n int) [][]uint64 {
+	subsets := make([][]uint64, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(decisions)-start)/(n-i)
+		subsets = append(subsets, decisions[start:end])
+		start = end
+	}
+	return subsets
+}
+
+// Returns decisions of all subsets but the i-th one.
+func complementOf(subsets [][]uint64, i int) []uint64 {
+	var complement []uint64
+	for j, subset := range subsets {
+		if j != i {
+			complement = append(complement, subset...)
+		}
+	}
+	return complement
+}
+
+func encode(decisions []uint64) []byte {
+	key := make([]byte, 0, 8*len(decisions))
+	for _, seqnum := range decisions {
+		for shift := uint(0); shift < 64; shift += 8 {
+			key = append(key, byte(seqnum>>shift))
+		}
+	}
+	return key
+}
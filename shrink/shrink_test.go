@@ -0,0 +1,94 @@
+package shrink
+
+import (
+	"hse-dss-efimov/journal"
+	"reflect"
+	"testing"
+)
+
+func seqnums(n int) []uint64 {
+	decisions := make([]uint64, n)
+	for i := range decisions {
+		decisions[i] = uint64(i + 1)
+	}
+	return decisions
+}
+
+func containsAll(kept []uint64, wanted ...uint64) bool {
+	for _, w := range wanted {
+		found := false
+		for _, k := range kept {
+			if k == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMinimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		culprits []uint64
+	}{
+		{"single", 16, []uint64{11}},
+		{"pair", 20, []uint64{3, 17}},
+		{"triple", 9, []uint64{1, 5, 9}},
+		{"none", 8, nil},
+		{"all", 3, []uint64{1, 2, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			result := Minimize(seqnums(test.n), func(kept []uint64) bool {
+				calls++
+				return containsAll(kept, test.culprits...)
+			})
+			if !reflect.DeepEqual(result, test.culprits) {
+				t.Errorf("expected %v, got %v after %v tests", test.culprits, result, calls)
+			}
+		})
+	}
+}
+
+func TestMinimizeTestsSubsetsOnce(t *testing.T) {
+	seen := make(map[string]bool)
+	Minimize(seqnums(10), func(kept []uint64) bool {
+		key := string(encode(kept))
+		if seen[key] {
+			t.Errorf("subset %v tested twice", kept)
+		}
+		seen[key] = true
+		return containsAll(kept, 2, 7)
+	})
+}
+
+func TestKeep(t *testing.T) {
+	records := []journal.Record{
+		{Kind: journal.KindReceive, Seqnum: 1},
+		{Kind: journal.KindReceive, Seqnum: 2},
+		{Kind: journal.KindModify, Seqnum: 2},
+		{Kind: journal.KindDecide, Seqnum: 2, Copies: 2},
+		{Kind: journal.KindReceive, Seqnum: 3, Origin: 2},
+		{Kind: journal.KindDecide, Seqnum: 3, Origin: 2, Copies: 1},
+		{Kind: journal.KindDecide, Seqnum: 1, Copies: 0},
+	}
+	if decisions := Decisions(records); !reflect.DeepEqual(decisions, []uint64{2, 1}) {
+		t.Errorf("unexpected decisions %v", decisions)
+	}
+	kept := Keep(records, []uint64{1})
+	var kinds []journal.Kind
+	for _, record := range kept {
+		kinds = append(kinds, record.Kind)
+	}
+	expected := []journal.Kind{journal.KindReceive, journal.KindReceive, journal.KindReceive, journal.KindDecide,
+		journal.KindDecide}
+	if !reflect.DeepEqual(kinds, expected) || kept[4].Seqnum != 1 {
+		t.Errorf("unexpected records %+v", kept)
+	}
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"fmt"
+	"hse-dss-efimov/network"
+	"sync"
+	"time"
+)
+
+type Status int
+
+const (
+	// Message awaits decision.
+	StatusPending Status = iota
+	// Message was accepted and awaits delivery.
+	StatusAccepted
+	// Message was rejected.
+	StatusRejected
+	// Message was written to its destination.
+	StatusSent
+)
+
+var statusNames = map[Status]string{
+	StatusPending:  "pending",
+	StatusAccepted: "accepted",
+	StatusRejected: "rejected",
+	StatusSent:     "sent",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+func ParseStatus(s string) (Status, error) {
+	for status, name := range statusNames {
+		if name == s {
+			return status, nil
+		}
+	}
+	return StatusPending, fmt.Errorf("unknown status %q", s)
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	status, err := ParseStatus(string(text))
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// History of a Message passing through a channel.
+type Record struct {
+	Seqnum   uint64 `json:"seqnum"`
+	Origin   uint64 `json:"origin,omitempty"`
+	Channel  string `json:"channel"`
+	Src      string `json:"src"`
+	Dst      string `json:"dst"`
+	Payload  []byte `json:"payload"`
+	Crc64    uint64 `json:"crc64"`
+	Tampered bool   `json:"tampered,omitempty"`
+	Injected bool   `json:"injected,omitempty"`
+	Status   Status `json:"status"`
+	// Number of deliveries the Message was accepted for.
+	Copies int `json:"copies,omitempty"`
+	// Session which decided on the Message; empty for automated decisions.
+	DecidedBy string    `json:"decidedBy,omitempty"`
+	Received  time.Time `json:"received"`
+	Decided   time.Time `json:"decided"`
+	Sent      time.Time `json:"sent"`
+
+	msg network.MessageI
+}
+
+// Returns the Message itself, so that it can be decided on.
+func (r Record) Message() network.MessageI {
+	return r.msg
+}
+
+// Criteria of records to look up; zero fields match anything.
+type Query struct {
+	Channel  string   `json:"channel,omitempty"`
+	Src      string   `json:"src,omitempty"`
+	Dst      string   `json:"dst,omitempty"`
+	Statuses []Status `json:"statuses,omitempty"`
+	// Bounds of reception time, inclusive.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+func (q Query) matches(r *Record) bool {
+	if q.Channel != "" && q.Channel != r.Channel {
+		return false
+	}
+	if q.Src != "" && q.Src != r.Src {
+		return false
+	}
+	if q.Dst != "" && q.Dst != r.Dst {
+		return false
+	}
+	if len(q.Statuses) > 0 {
+		found := false
+		for _, status := range q.Statuses {
+			if status == r.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.From.IsZero() && r.Received.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && r.Received.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// Keeps every Message passing through the channels it observes along with its
+// status. Safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex // protects records and order
+	records map[uint64]*Record
+	order   []*Record // in order of reception
+	now     func() time.Time
+}
+
+func NewStore() *Store {
+	return &Store{
+		records: make(map[uint64]*Record),
+		now:     time.Now,
+	}
+}
+
+func (s *Store) Received(msg network.MessageI) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[msg.GetSeqNum()]; ok {
+		return
+	}
+	r := &Record{
+		Seqnum:   msg.GetSeqNum(),
+		Origin:   msg.GetOrigin(),
+		Channel:  msg.GetChannel(),
+		Src:      msg.GetSrc(),
+		Dst:      msg.GetDst(),
+		Payload:  msg.GetPayload(),
+		Crc64:    msg.GetCrc64(),
+		Tampered: msg.IsTampered(),
+		Injected: msg.IsInjected(),
+		Status:   StatusPending,
+		Received: s.now(),
+		msg:      msg,
+	}
+	s.records[r.Seqnum] = r
+	s.order = append(s.order, r)
+}
+
+func (s *Store) Modified(msg network.MessageI) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[msg.GetSeqNum()]; ok {
+		r.Payload = msg.GetPayload()
+		r.Crc64 = msg.GetCrc64()
+		r.Tampered = msg.IsTampered()
+	}
+}
+
+func (s *Store) Decided(msg network.MessageI, copies int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[msg.GetSeqNum()]; ok && r.Status == StatusPending {
+		if copies > 0 {
+			r.Status = StatusAccepted
+		} else {
+			r.Status = StatusRejected
+		}
+		r.Copies = copies
+		r.Decided = s.now()
+	}
+}
+
+func (s *Store) Sent(msg network.MessageI) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.records[msg.GetSeqNum()]; ok {
+		r.Status = StatusSent
+		r.Sent = s.now()
+	}
+}
+
+// Claims pending Message for a decision by the given session. Returns false if
+// the Message is unknown, decided already or claimed by another session.
+func (s *Store) Claim(seqnum uint64, session string) (network.MessageI, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[seqnum]
+	if !ok || r.Status != StatusPending || r.DecidedBy != "" {
+		return nil, false
+	}
+	r.DecidedBy = session
+	return r.msg, true
+}
+
+// Returns a copy of the record of the given Message.
+func (s *Store) Get(seqnum uint64) (Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if r, ok := s.records[seqnum]; ok {
+		return *r, true
+	}
+	return Record{}, false
+}
+
+// Returns copies of matching records in order of reception.
+func (s *Store) Query(q Query) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]Record, 0)
+	for _, r := range s.order {
+		if q.matches(r) {
+			records = append(records, *r)
+		}
+	}
+	return records
+}
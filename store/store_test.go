@@ -0,0 +1,79 @@
+package store
+
+import (
+	"hse-dss-efimov/network"
+	"testing"
+	"time"
+)
+
+func newTestStore() *Store {
+	s := NewStore()
+	now := time.Unix(0, 0)
+	s.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return s
+}
+
+func TestStoreLifecycle(t *testing.T) {
+	s := newTestStore()
+	msg := &network.Message{Seqnum: 1, Channel: "1-2", Src: "1", Dst: "2", Payload: []byte{'x'}}
+
+	s.Received(msg)
+	if _, ok := s.Claim(2, "a"); ok {
+		t.Fatalf("unknown Message must not be claimed")
+	}
+	if claimed, ok := s.Claim(1, "a"); !ok || claimed != network.MessageI(msg) {
+		t.Fatalf("pending Message must be claimed")
+	}
+	if _, ok := s.Claim(1, "b"); ok {
+		t.Fatalf("claimed Message must not be claimed again")
+	}
+
+	s.Decided(msg, 2)
+	s.Sent(msg)
+	r, ok := s.Get(1)
+	if !ok {
+		t.Fatalf("Message must be recorded")
+	}
+	if r.Status != StatusSent || r.Copies != 2 || r.DecidedBy != "a" {
+		t.Errorf("unexpected record: status %v, copies %v, decided by %q", r.Status, r.Copies, r.DecidedBy)
+	}
+	if !r.Received.Before(r.Decided) || !r.Decided.Before(r.Sent) {
+		t.Errorf("unexpected timestamps: %v, %v, %v", r.Received, r.Decided, r.Sent)
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s := newTestStore()
+	msgs := []*network.Message{
+		{Seqnum: 1, Channel: "1-2", Src: "1", Dst: "2"},
+		{Seqnum: 2, Channel: "1-2", Src: "2", Dst: "1"},
+		{Seqnum: 3, Channel: "3-4", Src: "3", Dst: "4"},
+	}
+	for _, msg := range msgs {
+		s.Received(msg)
+	}
+	s.Decided(msgs[1], 0)
+
+	check := func(q Query, expected ...uint64) {
+		t.Helper()
+		records := s.Query(q)
+		if len(records) != len(expected) {
+			t.Fatalf("unexpected number of records: %v", len(records))
+		}
+		for i, r := range records {
+			if r.Seqnum != expected[i] {
+				t.Errorf("unexpected record %v at %v", r.Seqnum, i)
+			}
+		}
+	}
+	check(Query{}, 1, 2, 3)
+	check(Query{Channel: "1-2"}, 1, 2)
+	check(Query{Src: "2"}, 2)
+	check(Query{Dst: "4"}, 3)
+	check(Query{Statuses: []Status{StatusPending}}, 1, 3)
+	check(Query{Statuses: []Status{StatusRejected, StatusSent}}, 2)
+	check(Query{From: time.Unix(2, 0), To: time.Unix(2, 0)}, 2)
+}
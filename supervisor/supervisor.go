@@ -0,0 +1,417 @@
+package supervisor
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type RestartPolicy int
+
+const (
+	// Exited node stays down.
+	RestartNever RestartPolicy = iota
+	// Node is restarted if it exits with an error or is killed by a signal.
+	RestartOnFailure
+	// Node is restarted whenever it exits.
+	RestartAlways
+)
+
+var restartPolicyNames = map[RestartPolicy]string{
+	RestartNever:     "never",
+	RestartOnFailure: "on-failure",
+	RestartAlways:    "always",
+}
+
+func (p RestartPolicy) String() string {
+	if name, ok := restartPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("RestartPolicy(%d)", int(p))
+}
+
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	if s == "" {
+		return RestartNever, nil
+	}
+	for policy, name := range restartPolicyNames {
+		if name == s {
+			return policy, nil
+		}
+	}
+	return RestartNever, fmt.Errorf("unknown restart policy %q", s)
+}
+
+func (p RestartPolicy) restarts(err error) bool {
+	return p == RestartAlways || (p == RestartOnFailure && err != nil)
+}
+
+const defaultRestartDelay = time.Second
+
+// Observer of node processes starting and exiting.
+type Observer interface {
+	NodeStarted(node string, pid int)
+	NodeExited(node string, pid int, status string)
+}
+
+// Supervised node process.
+type node struct {
+	name    string
+	command string
+	args    []string
+	dir     string
+	env     []string
+	restart RestartPolicy
+
+	// Running process, nil while the node is down.
+	cmd *exec.Cmd
+	// Closed once the running process exits.
+	exited chan struct{}
+	// Set while the node is being stopped on purpose, so that it is not restarted.
+	stopping bool
+	// Set while the process group is stopped with SIGSTOP.
+	paused bool
+}
+
+// Runs nodes of a topology, writing their standard output and error into
+// NAME.stdout and NAME.stderr in the log directory and restarting exited ones
+// by their restart policies.
+type Supervisor struct {
+	logger       zap.Logger
+	logDir       string
+	restartDelay time.Duration
+	// Time given to a node to exit on SIGTERM before it is killed.
+	stopTimeout time.Duration
+
+	mu       sync.Mutex
+	nodes    []*node
+	observer Observer
+	closed   bool
+	closeCh  chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Renders node templates of a valid topology; nodes are not started until Start.
+func NewSupervisor(topology Topology, logDir string, stopTimeout time.Duration, logger zap.Logger) (*Supervisor, error) {
+	if err := topology.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Supervisor{
+		logger:       logger,
+		logDir:       logDir,
+		restartDelay: topology.RestartDelay,
+		stopTimeout:  stopTimeout,
+		closeCh:      make(chan struct{}),
+	}
+	if s.restartDelay <= 0 {
+		s.restartDelay = defaultRestartDelay
+	}
+	for i, spec := range topology.Nodes {
+		data := topology.dataOf(i)
+		n := &node{name: spec.Name, command: spec.Command}
+		n.restart, _ = ParseRestartPolicy(spec.Restart)
+
+		var err error
+		if n.dir, err = render(spec.Dir, data); err != nil {
+			return nil, fmt.Errorf("node %v: cannot render dir: %v", spec.Name, err)
+		}
+		for _, arg := range spec.Args {
+			rendered, err := render(arg, data)
+			if err != nil {
+				return nil, fmt.Errorf("node %v: cannot render argument %q: %v", spec.Name, arg, err)
+			}
+			n.args = append(n.args, rendered)
+		}
+		for _, env := range spec.Env {
+			rendered, err := render(env, data)
+			if err != nil {
+				return nil, fmt.Errorf("node %v: cannot render environment %q: %v", spec.Name, env, err)
+			}
+			n.env = append(n.env, rendered)
+		}
+		s.nodes = append(s.nodes, n)
+	}
+	return s, nil
+}
+
+// Starts every node; stops the started ones if any fails to start.
+func (s *Supervisor) Start() error {
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		return err
+	}
+	for _, n := range s.nodes {
+		s.mu.Lock()
+		err := s.start(n)
+		s.mu.Unlock()
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("cannot start node %v: %v", n.name, err)
+		}
+	}
+	return nil
+}
+
+// Returns names of the nodes in topology order.
+func (s *Supervisor) Nodes() []string {
+	names := make([]string, len(s.nodes))
+	for i, n := range s.nodes {
+		names[i] = n.name
+	}
+	return names
+}
+
+// Returns pid of the running process of the node, 0 if it is down.
+func (s *Supervisor) Pid(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n := s.node(name); n != nil && n.cmd != nil {
+		return n.cmd.Process.Pid
+	}
+	return 0
+}
+
+func (s *Supervisor) node(name string) *node {
+	for _, n := range s.nodes {
+		if n.name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// Sets observer notified about nodes starting and exiting; nil removes it.
+func (s *Supervisor) SetObserver(observer Observer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observer = observer
+}
+
+// Kills process group of the running node with SIGKILL and waits for it to exit.
+// Killed node stays down until restarted.
+func (s *Supervisor) Kill(name string) error {
+	return s.stopNode(name, syscall.SIGKILL)
+}
+
+// Stops the running node with SIGTERM, killing it if it does not exit within
+// stop timeout. Stopped node stays down until restarted.
+func (s *Supervisor) Stop(name string) error {
+	return s.stopNode(name, syscall.SIGTERM)
+}
+
+func (s *Supervisor) stopNode(name string, sig syscall.Signal) error {
+	s.mu.Lock()
+	n := s.node(name)
+	running := n != nil && n.cmd != nil
+	s.mu.Unlock()
+	if n == nil {
+		return fmt.Errorf("unknown node %q", name)
+	}
+	if !running {
+		return fmt.Errorf("node %v is down", name)
+	}
+	s.stop(n, sig)
+	return nil
+}
+
+// Freezes the running node by sending SIGSTOP to its process group.
+func (s *Supervisor) Pause(name string) error {
+	return s.signalNode(name, syscall.SIGSTOP, false)
+}
+
+// Resumes the paused node by sending SIGCONT to its process group.
+func (s *Supervisor) Resume(name string) error {
+	return s.signalNode(name, syscall.SIGCONT, true)
+}
+
+func (s *Supervisor) signalNode(name string, sig syscall.Signal, resume bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.node(name)
+	switch {
+	case n == nil:
+		return fmt.Errorf("unknown node %q", name)
+	case n.cmd == nil:
+		return fmt.Errorf("node %v is down", name)
+	case resume && !n.paused:
+		return fmt.Errorf("node %v is not paused", name)
+	case !resume && n.paused:
+		return fmt.Errorf("node %v is paused already", name)
+	}
+	if err := syscall.Kill(-n.cmd.Process.Pid, sig); err != nil {
+		return err
+	}
+	n.paused = !resume
+	s.logger.Info("signalled node", zap.String("node", n.name), zap.Stringer("signal", sig))
+	return nil
+}
+
+// Starts the node, stopping it first if it is running.
+func (s *Supervisor) Restart(name string) error {
+	s.mu.Lock()
+	n := s.node(name)
+	s.mu.Unlock()
+	if n == nil {
+		return fmt.Errorf("unknown node %q", name)
+	}
+	s.stop(n, syscall.SIGTERM)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("supervisor is closed")
+	}
+	if n.cmd != nil {
+		// Restarted concurrently.
+		return nil
+	}
+	return s.start(n)
+}
+
+func (s *Supervisor) openLog(n *node, stream string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(s.logDir, n.name+"."+stream), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// Starts process of the node; must be called with mu held.
+func (s *Supervisor) start(n *node) error {
+	stdout, err := s.openLog(n, "stdout")
+	if err != nil {
+		return err
+	}
+	stderr, err := s.openLog(n, "stderr")
+	if err != nil {
+		stdout.Close()
+		return err
+	}
+
+	cmd := exec.Command(n.command, n.args...)
+	cmd.Dir = n.dir
+	cmd.Env = append(os.Environ(), n.env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Own process group lets the whole node be signalled, whatever the command spawns.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		return err
+	}
+	n.cmd = cmd
+	n.exited = make(chan struct{})
+	n.stopping = false
+	n.paused = false
+	s.logger.Info("started node",
+		zap.String("node", n.name),
+		zap.Int("pid", cmd.Process.Pid),
+		zap.Strings("args", cmd.Args))
+	if s.observer != nil {
+		s.observer.NodeStarted(n.name, cmd.Process.Pid)
+	}
+
+	s.wg.Add(1)
+	go s.watch(n, cmd, stdout, stderr)
+	return nil
+}
+
+// Waits for the process of the node to exit and restarts it if the policy says so.
+func (s *Supervisor) watch(n *node, cmd *exec.Cmd, stdout *os.File, stderr *os.File) {
+	defer s.wg.Done()
+
+	err := cmd.Wait()
+	stdout.Close()
+	stderr.Close()
+
+	s.mu.Lock()
+	n.cmd = nil
+	close(n.exited)
+	restart := !n.stopping && !s.closed && n.restart.restarts(err)
+	observer := s.observer
+	s.mu.Unlock()
+
+	if observer != nil {
+		observer.NodeExited(n.name, cmd.Process.Pid, cmd.ProcessState.String())
+	}
+
+	s.logger.Info("node exited",
+		zap.String("node", n.name),
+		zap.Int("pid", cmd.Process.Pid),
+		zap.Stringer("status", cmd.ProcessState),
+		zap.Bool("restart", restart))
+	if !restart {
+		return
+	}
+
+	select {
+	case <-s.closeCh:
+		return
+	case <-time.After(s.restartDelay):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || n.stopping || n.cmd != nil {
+		return
+	}
+	if err := s.start(n); err != nil {
+		s.logger.Error("cannot restart node", zap.String("node", n.name), zap.Error(err))
+	}
+}
+
+// Signals process group of the node and waits for it to exit; processes not
+// exiting within stop timeout are killed.
+func (s *Supervisor) stop(n *node, sig syscall.Signal) {
+	s.mu.Lock()
+	cmd, exited, paused := n.cmd, n.exited, n.paused
+	n.stopping = true
+	s.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	pid := cmd.Process.Pid
+	syscall.Kill(-pid, sig)
+	if paused {
+		// Paused node handles the signal once resumed.
+		syscall.Kill(-pid, syscall.SIGCONT)
+	}
+	if sig == syscall.SIGKILL {
+		<-exited
+		return
+	}
+	select {
+	case <-exited:
+	case <-time.After(s.stopTimeout):
+		s.logger.Warn("node did not stop in time, killing", zap.String("node", n.name), zap.Int("pid", pid))
+		syscall.Kill(-pid, syscall.SIGKILL)
+		<-exited
+	}
+}
+
+// Stops every node with SIGTERM and waits for pending restarts to be abandoned.
+func (s *Supervisor) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.closeCh)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, n := range s.nodes {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			s.stop(n, syscall.SIGTERM)
+		}(n)
+	}
+	wg.Wait()
+	s.wg.Wait()
+}
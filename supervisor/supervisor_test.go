@@ -0,0 +1,265 @@
+package supervisor
+
+import (
+	"go.uber.org/zap"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func twoNodes() Topology {
+	return Topology{Nodes: []Node{
+		{Name: "A", Command: "sh", Port: 7002, Peers: map[string]int{"b": 7001}},
+		{Name: "B", Command: "sh", Port: 7102, Peers: map[string]int{"a": 7101}},
+	}}
+}
+
+func TestTopologyChannels(t *testing.T) {
+	topology := twoNodes()
+	if err := topology.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Channel{{Src: 7001, Dst: 7102}, {Src: 7101, Dst: 7002}}
+	if channels := topology.Channels(); !reflect.DeepEqual(channels, expected) {
+		t.Errorf("expected %v, got %v", expected, channels)
+	}
+}
+
+func TestTopologyValidate(t *testing.T) {
+	unknownPeer := twoNodes()
+	unknownPeer.Nodes[0].Peers = map[string]int{"c": 7001}
+	sharedPort := twoNodes()
+	sharedPort.Nodes[1].Peers = map[string]int{"a": 7001}
+	badPolicy := twoNodes()
+	badPolicy.Nodes[0].Restart = "sometimes"
+	for name, topology := range map[string]Topology{
+		"unknown peer": unknownPeer,
+		"shared port":  sharedPort,
+		"bad policy":   badPolicy,
+		"empty":        {},
+	} {
+		if err := topology.Validate(); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
+
+func TestSupervisorRendersTemplatesAndCapturesOutput(t *testing.T) {
+	dir := t.TempDir()
+	topology := twoNodes()
+	topology.Nodes[0].Args = []string{"-c", `echo "$GREETING {{.Index}} {{.Port}} {{.Peers.B}}"; echo oops >&2`}
+	topology.Nodes[0].Env = []string{"GREETING=hello {{.Name}}"}
+	topology.Nodes[1].Args = []string{"-c", "pwd"}
+	topology.Nodes[1].Dir = dir
+
+	s, err := NewSupervisor(topology, filepath.Join(dir, "logs"), time.Second, *zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range s.Nodes() {
+		for s.Pid(name) != 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	s.Close()
+
+	for file, expected := range map[string]string{
+		"A.stdout": "hello A 0 7002 7001\n",
+		"A.stderr": "oops\n",
+		"B.stdout": dir + "\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "logs", file))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != expected {
+			t.Errorf("%v: expected %q, got %q", file, expected, data)
+		}
+	}
+}
+
+func TestSupervisorRestartsByPolicy(t *testing.T) {
+	dir := t.TempDir()
+	topology := twoNodes()
+	topology.RestartDelay = 10 * time.Millisecond
+	topology.Nodes[0].Args = []string{"-c", "echo run; exit 1"}
+	topology.Nodes[0].Restart = "on-failure"
+	topology.Nodes[1].Args = []string{"-c", "echo run"}
+	topology.Nodes[1].Restart = "on-failure"
+
+	s, err := NewSupervisor(topology, dir, time.Second, *zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	s.Close()
+
+	runs := func(name string) int {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name+".stdout"))
+		return strings.Count(string(data), "run")
+	}
+	if runs("A") < 3 {
+		t.Errorf("failing node must be restarted, ran %d times", runs("A"))
+	}
+	if runs("B") != 1 {
+		t.Errorf("succeeding node must not be restarted, ran %d times", runs("B"))
+	}
+}
+
+func TestSupervisorCloseKillsStubbornNodes(t *testing.T) {
+	topology := twoNodes()
+	topology.Nodes[0].Args = []string{"-c", "trap '' TERM; while :; do sleep 0.01; done"}
+	topology.Nodes[0].Restart = "always"
+	topology.Nodes[1].Args = []string{"-c", "exec sleep 10"}
+
+	s, err := NewSupervisor(topology, t.TempDir(), 100*time.Millisecond, *zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	started := time.Now()
+	s.Close()
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("close took %v", elapsed)
+	}
+	for _, name := range s.Nodes() {
+		if pid := s.Pid(name); pid != 0 {
+			t.Errorf("node %v must be down, runs as %d", name, pid)
+		}
+	}
+}
+
+type fakeObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *fakeObserver) add(event string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, event)
+}
+
+func (o *fakeObserver) NodeStarted(node string, pid int) {
+	o.add("start " + node)
+}
+
+func (o *fakeObserver) NodeExited(node string, pid int, status string) {
+	o.add("exit " + node + ": " + status)
+}
+
+func TestSupervisorKillAndRestart(t *testing.T) {
+	topology := twoNodes()
+	topology.RestartDelay = 10 * time.Millisecond
+	topology.Nodes[0].Args = []string{"-c", "exec sleep 10"}
+	topology.Nodes[0].Restart = "always"
+	topology.Nodes[1].Args = []string{"-c", "exec sleep 10"}
+
+	s, err := NewSupervisor(topology, t.TempDir(), time.Second, *zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	observer := &fakeObserver{}
+	s.SetObserver(observer)
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Kill("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pid := s.Pid("A"); pid != 0 {
+		t.Errorf("killed node must stay down despite its restart policy, runs as %d", pid)
+	}
+	if err := s.Kill("A"); err == nil {
+		t.Errorf("expected error killing node which is down")
+	}
+	if err := s.Stop("C"); err == nil {
+		t.Errorf("expected error stopping unknown node")
+	}
+	if err := s.Restart("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid := s.Pid("A"); pid == 0 {
+		t.Errorf("restarted node must run")
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	expected := []string{"start A", "start B", "exit A: signal: killed", "start A"}
+	if !reflect.DeepEqual(observer.events, expected) {
+		t.Errorf("expected events %v, got %v", expected, observer.events)
+	}
+}
+
+func TestSupervisorPauseAndResume(t *testing.T) {
+	dir := t.TempDir()
+	topology := twoNodes()
+	topology.Nodes[0].Args = []string{"-c", "trap 'exit 0' TERM; while :; do echo tick; sleep 0.01; done"}
+	topology.Nodes[1].Args = []string{"-c", "exec sleep 10"}
+
+	s, err := NewSupervisor(topology, dir, 5*time.Second, *zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	ticks := func() int {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "A.stdout"))
+		return strings.Count(string(data), "tick")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Resume("A"); err == nil {
+		t.Errorf("expected error resuming node which is not paused")
+	}
+	if err := s.Pause("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Pause("A"); err == nil {
+		t.Errorf("expected error pausing node twice")
+	}
+	time.Sleep(20 * time.Millisecond)
+	paused := ticks()
+	time.Sleep(100 * time.Millisecond)
+	if n := ticks(); n != paused {
+		t.Errorf("paused node must not run, ticked %d times", n-paused)
+	}
+	if err := s.Resume("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ticks() == paused {
+		t.Errorf("resumed node must run")
+	}
+
+	// Paused node handles SIGTERM rather than waiting for stop timeout to be killed.
+	if err := s.Pause("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	started := time.Now()
+	if err := s.Stop("A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("stopping paused node took %v", elapsed)
+	}
+}
@@ -0,0 +1,154 @@
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Node of a topology: a process listening on Port and reaching every peer
+// through a channel, whose source port is given in Peers by name of the peer.
+//
+// Dir, Args and values of Env entries of the form KEY=VALUE are templates
+// rendered with fields Name, Index, Port and Peers of the node.
+type Node struct {
+	Name    string   `mapstructure:"name"`
+	Command string   `mapstructure:"command"`
+	Args    []string `mapstructure:"args"`
+	Dir     string   `mapstructure:"dir"`
+	Env     []string `mapstructure:"env"`
+	Port    int      `mapstructure:"port"`
+	// Node names are matched case-insensitively, as config files lowercase keys.
+	Peers map[string]int `mapstructure:"peers"`
+	// Restart policy: never, on-failure or always.
+	Restart string `mapstructure:"restart"`
+}
+
+// Nodes of the system under test and channels between them.
+type Topology struct {
+	Nodes []Node `mapstructure:"nodes"`
+	// Pause before a node is restarted.
+	RestartDelay time.Duration `mapstructure:"restart-delay"`
+}
+
+// Channel from the port a node connects to towards the port its peer listens on.
+type Channel struct {
+	Src int
+	Dst int
+}
+
+// Data node templates are rendered with.
+type nodeData struct {
+	Name  string
+	Index int
+	Port  int
+	Peers map[string]int
+}
+
+func (t Topology) nodeIndex() map[string]int {
+	index := make(map[string]int, len(t.Nodes))
+	for i, n := range t.Nodes {
+		index[strings.ToLower(n.Name)] = i
+	}
+	return index
+}
+
+// Returns error if nodes are unnamed, share names or ports, or refer to unknown peers.
+func (t Topology) Validate() error {
+	if len(t.Nodes) == 0 {
+		return fmt.Errorf("topology has no nodes")
+	}
+	index := t.nodeIndex()
+	if len(index) != len(t.Nodes) {
+		return fmt.Errorf("node names must be unique")
+	}
+	used := make(map[int]string)
+	use := func(port int, what string) error {
+		if port <= 0 {
+			return fmt.Errorf("%v has no port", what)
+		}
+		if other, ok := used[port]; ok {
+			return fmt.Errorf("%v and %v share port %d", what, other, port)
+		}
+		used[port] = what
+		return nil
+	}
+	for _, n := range t.Nodes {
+		if n.Name == "" {
+			return fmt.Errorf("node has no name")
+		}
+		if n.Command == "" {
+			return fmt.Errorf("node %v has no command", n.Name)
+		}
+		if _, err := ParseRestartPolicy(n.Restart); err != nil {
+			return fmt.Errorf("node %v: %v", n.Name, err)
+		}
+		if err := use(n.Port, "node "+n.Name); err != nil {
+			return err
+		}
+		for peer, port := range n.Peers {
+			if _, ok := index[strings.ToLower(peer)]; !ok {
+				return fmt.Errorf("node %v refers to unknown peer %v", n.Name, peer)
+			}
+			if err := use(port, fmt.Sprintf("channel from %v to %v", n.Name, peer)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns channels between nodes of a valid topology, ordered by source port.
+func (t Topology) Channels() []Channel {
+	index := t.nodeIndex()
+	var channels []Channel
+	for _, n := range t.Nodes {
+		for peer, port := range n.Peers {
+			channels = append(channels, Channel{Src: port, Dst: t.Nodes[index[strings.ToLower(peer)]].Port})
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Src < channels[j].Src
+	})
+	return channels
+}
+
+// Returns ports of every node of a valid topology: the one it listens on
+// followed by the ones it connects to, in ascending order.
+func (t Topology) Ports() map[string][]int {
+	ports := make(map[string][]int, len(t.Nodes))
+	for _, n := range t.Nodes {
+		var peers []int
+		for _, port := range n.Peers {
+			peers = append(peers, port)
+		}
+		sort.Ints(peers)
+		ports[n.Name] = append([]int{n.Port}, peers...)
+	}
+	return ports
+}
+
+func (t Topology) dataOf(i int) nodeData {
+	index := t.nodeIndex()
+	n := t.Nodes[i]
+	peers := make(map[string]int, len(n.Peers))
+	for peer, port := range n.Peers {
+		peers[t.Nodes[index[strings.ToLower(peer)]].Name] = port
+	}
+	return nodeData{Name: n.Name, Index: i, Port: n.Port, Peers: peers}
+}
+
+func render(text string, data nodeData) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -3,6 +3,7 @@ $(document).ready(function() {
     var socket = new WebSocket("ws://localhost:8080/ws");
     var counter = t.rows()[0].length;
     var nums_list = [];
+    var row_ids = {};
 
     // Handle Connection ___________________________________________________
 
@@ -32,24 +33,110 @@ $(document).ready(function() {
 
     socket.onmessage = function(event) {
         var data = JSON.parse(event.data);
+        if (data.kind !== undefined) {
+            // Replies to requests are not rows of the table.
+            if (data.request === "pending") {
+                show_depths(data.depths || {});
+            }
+            if (data.request === "release" && data.data) {
+                alert("Cannot release messages: " + data.data);
+            }
+            if (data.request === "fault" && data.data) {
+                alert("Cannot trigger fault: " + data.data);
+            }
+            if (data.request === "modify" && data.data) {
+                alert("Cannot modify message " + data.msgNumber + ": " + data.data);
+            }
+            if (data.kind === 4 && row_ids[data.msgNumber] !== undefined) {
+                // Decision made in another view.
+                if (data.data === "4") {
+                    show_modification(row_ids[data.msgNumber], data.payload);
+                } else {
+                    show_decision(row_ids[data.msgNumber], parseInt(data.data) || 0, data.delay);
+                }
+            }
+            return;
+        }
         if (nums_list.indexOf(data.msgNumber) !== -1) {
             return;
         }
 
+        var actions =
+            '<button id="accept" type="button" class="btn btn-success">Accept</button>' +
+            '<button id="modify" type="button" class="btn btn-secondary">Edit</button>' +
+            '<button id="duplicate" type="button" class="btn btn-warning">Duplicate</button>' +
+            '<button id="delay" type="button" class="btn btn-info">Delay</button>' +
+            '<button id="denied" type="button" class="btn btn-danger">Reject</button>';
+        if (data.origin) {
+            // Copies are accepted along with their original.
+            actions = '<div class="alert alert-warning" role="alert"> Copy of message ' +
+                $('<div/>').text(data.origin).html() + ' </div>';
+        }
         t.row.add([
             data.msgNumber,
             data.src,
             data.dst,
-            data.payload,
-            '<button id="accept" type="button" class="btn btn-success">Accept</button>' +
-            '<button id="denied" type="button" class="btn btn-danger">Reject</button>'
+            payload_cell(data.payload, data.tampered, data.injected),
+            actions
         ]).node().id = counter;
+        row_ids[data.msgNumber] = counter;
         counter += 1;
         nums_list.push(data.msgNumber);
         t.draw(false);
     };
 
-    function send_response(that, decision) {
+    function show_depths(depths) {
+        var text = $.map(Object.keys(depths).sort(), function(name) {
+            return name + ": " + depths[name];
+        }).join(", ");
+        $("#pending").text("Awaiting decision: " + (text || "none"));
+    }
+
+    setInterval(function() {
+        if (socket.readyState === WebSocket.OPEN) {
+            socket.send(JSON.stringify({
+                kind: 2,
+                request: "pending"
+            }));
+        }
+    }, 2000);
+
+    function payload_cell(payload, tampered, injected) {
+        var cell = $('<div/>').text(payload).html();
+        if (tampered) {
+            cell += ' <span class="badge badge-warning">tampered</span>';
+        }
+        if (injected) {
+            cell += ' <span class="badge badge-danger">injected</span>';
+        }
+        return cell;
+    }
+
+    function send_modification(that) {
+        var row_ind = parseInt(that.parentElement.parentElement.id);
+        var t = $('#table').DataTable();
+        var trow = t.row(row_ind).data();
+        var payload = window.prompt("Payload", $('<div/>').html(trow[3]).contents().first().text());
+        if (payload === null) {
+            return;
+        }
+        socket.send(JSON.stringify({
+            kind: 3,
+            msgNumber: trow[0].toString(),
+            data: "4",
+            payload: payload
+        }));
+        // Row is updated once the modification is broadcast back.
+    }
+
+    function show_modification(row_ind, payload) {
+        var t = $('#table').DataTable();
+        var trow = t.row(row_ind).data();
+        trow[3] = payload_cell(payload, true);
+        t.row(row_ind).data(trow).invalidate()
+    }
+
+    function send_response(that, decision, delay) {
         var row_ind = parseInt(that.parentElement.parentElement.id);
         var t = $('#table').DataTable();
         var trow = t.row(row_ind).data();
@@ -57,9 +144,20 @@ $(document).ready(function() {
         socket.send(JSON.stringify({
             kind: 3,
             msgNumber: msgNumber.toString(),
-            data: decision.toString()
+            data: decision.toString(),
+            delay: delay
         }));
-        if (decision) {
+        show_decision(row_ind, decision, delay);
+    }
+
+    function show_decision(row_ind, decision, delay) {
+        var t = $('#table').DataTable();
+        var trow = t.row(row_ind).data();
+        if (decision === 3) {
+            trow[4] = '<div class="alert alert-info" role="alert"> Message accepted in ' + delay + ' ms </div>';
+        } else if (decision === 2) {
+            trow[4] = '<div class="alert alert-warning" role="alert"> Message duplicated </div>';
+        } else if (decision) {
             trow[4] = '<div class="alert alert-success" role="alert"> Message accepted </div>';
         } else {
             trow[4] = '<div class="alert alert-danger" role="alert"> Message rejected </div>';
@@ -71,9 +169,43 @@ $(document).ready(function() {
         send_response(this, 1)
     });
 
+    $(document).on("click", "#duplicate", function() {
+        send_response(this, 2)
+    });
+
+    $(document).on("click", "#delay", function() {
+        var delay = parseInt(window.prompt("Delay, ms", "1000"));
+        if (!isNaN(delay)) {
+            send_response(this, 3, delay)
+        }
+    });
+
+    $(document).on("click", "#modify", function() {
+        send_modification(this)
+    });
+
     $(document).on("click", "#denied", function() {
         send_response(this, 0)
     });
 
+    $(document).on("click", "#release", function() {
+        socket.send(JSON.stringify({
+            kind: 2,
+            request: "release",
+            data: $("#release-order").val()
+        }));
+        $("#release-order").val("");
+    });
+
+    $(document).on("click", "#fault", function() {
+        socket.send(JSON.stringify({
+            kind: 2,
+            request: "fault",
+            channel: $("#fault-channel").val(),
+            data: $("#fault-kind").val(),
+            delay: parseInt($("#fault-duration").val()) || 0
+        }));
+    });
+
 });
 
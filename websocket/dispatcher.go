@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"go.uber.org/zap"
+	"hse-dss-efimov/network"
 	"sync"
 )
 
@@ -15,6 +16,7 @@ type Dispatcher struct {
 	sessions     map[*session]bool
 	registerCh   chan *session
 	unregisterCh chan *session
+	broadcastCh  chan interface{}
 }
 
 func NewDispatcher(logger zap.Logger, callHandler CallHandler) *Dispatcher {
@@ -25,6 +27,7 @@ func NewDispatcher(logger zap.Logger, callHandler CallHandler) *Dispatcher {
 		sessions:     make(map[*session]bool),
 		registerCh:   make(chan *session),
 		unregisterCh: make(chan *session),
+		broadcastCh:  make(chan interface{}),
 	}
 	d.closeWg.Add(1)
 	go d.run()
@@ -46,11 +49,66 @@ func (d *Dispatcher) run() {
 			if _, ok := d.sessions[s]; ok {
 				d.unregisterSession(s)
 			}
+		case msg := <-d.broadcastCh:
+			for s := range d.sessions {
+				select {
+				case s.queue <- msg:
+				default:
+					// Lagging session would stall everyone else; it is dropped and may reconnect.
+					d.logger.Warn("session queue overflow", zap.String("session_id", s.id))
+					d.unregisterSession(s)
+				}
+			}
+		}
+	}
+}
+
+// Sends JSON message to every registered session.
+func (d *Dispatcher) Broadcast(msg interface{}) {
+	select {
+	case <-d.closeCh:
+	case d.broadcastCh <- msg:
+	}
+}
+
+// Broadcasts messages handed out for decision until the dispatcher is closed.
+func (d *Dispatcher) Forward(msgDbChan *Chans_ports) {
+	d.closeWg.Add(1)
+	go func() {
+		defer d.closeWg.Done()
+		for {
+			select {
+			case <-d.closeCh:
+				return
+			case msg := <-msgDbChan.MsgChan:
+				d.Broadcast(wsMessageFor(&msg))
+			}
 		}
+	}()
+}
+
+// Broadcasts copies made by Duplicate decisions, so that every copy shows up
+// in the views as an event of its own.
+type copyObserver struct {
+	d *Dispatcher
+}
+
+func (o copyObserver) Received(msg network.MessageI) {
+	if msg.GetOrigin() != 0 {
+		o.d.Broadcast(wsMessageFor(msg))
 	}
 }
 
+func (o copyObserver) Modified(msg network.MessageI) {}
+
+func (o copyObserver) Decided(msg network.MessageI, copies int) {}
 
+func (o copyObserver) Sent(msg network.MessageI) {}
+
+// Returns observer of channels broadcasting copies of duplicated messages.
+func (d *Dispatcher) CopyObserver() network.Observer {
+	return copyObserver{d}
+}
 
 func (d *Dispatcher) registerSession(s *session) {
 	if _, ok := d.sessions[s]; !ok {
@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"go.uber.org/zap"
+	"hse-dss-efimov/network"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCopyObserverBroadcastsCopies(t *testing.T) {
+	d := NewDispatcher(*zap.NewNop(), nil)
+	defer d.Close()
+	s := &session{id: "test", queue: make(chan interface{}, 10)}
+	d.registerCh <- s
+
+	observer := d.CopyObserver()
+	observer.Received(&network.Message{Seqnum: 1, Payload: []byte("x")})
+	observer.Received(&network.Message{Seqnum: 2, Origin: 1, Payload: []byte("x")})
+
+	expected := []interface{}{
+		Message{Kind: MK_Hello},
+		wsMessage{MsgNumber: "2", Origin: "1", Payload: "x"},
+	}
+	for _, e := range expected {
+		select {
+		case msg := <-s.queue:
+			if !reflect.DeepEqual(msg, e) {
+				t.Errorf("expected %+v, got %+v", e, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %+v, got nothing", e)
+		}
+	}
+	select {
+	case msg := <-s.queue:
+		t.Errorf("original Message must not be broadcast, got %+v", msg)
+	default:
+	}
+}
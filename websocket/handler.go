@@ -37,11 +37,10 @@ func HttpHandler(dispatcher *Dispatcher, w http.ResponseWriter, r *http.Request,
 		id:     sessionId,
 		logger: *sessionLogger,
 		conn:   conn,
-		queue:  make(chan Message, queueCapacity),
-		db:     msg_db_chan.MsgsDb,
+		queue:  make(chan interface{}, queueCapacity),
 	}
 
 	dispatcher.registerCh <- session
-	session.runLoop(r.Context(), dispatcher.callHandler, msg_db_chan)
+	session.runLoop(r.Context(), dispatcher, msg_db_chan)
 	dispatcher.unregisterCh <- session
 }
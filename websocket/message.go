@@ -2,7 +2,9 @@ package websocket
 
 import (
 	"encoding/json"
+	"hse-dss-efimov/nemesis"
 	"hse-dss-efimov/network"
+	"hse-dss-efimov/store"
 )
 
 type MessageKind int
@@ -19,18 +21,48 @@ type Message struct {
 	MsgNumber string      `json:"msgNumber,omitempty"`
 	Data      string `json:"data,omitempty"`
 	Request   string      `json:"request,omitempty"`
+	Copies    int         `json:"copies,omitempty"`
+	// Delay of acceptance or duration of a fault in milliseconds.
+	Delay     int64       `json:"delay,omitempty"`
+	// Acceptance deadline in RFC 3339 format; takes precedence over delay.
+	At        string      `json:"at,omitempty"`
+	// Replacement payload of a pending message or payload of an injected one.
+	Payload   string      `json:"payload,omitempty"`
+	// Name of the channel to inject a message into or to fault.
+	Channel   string      `json:"channel,omitempty"`
+	// Name of the partition to create or heal, or of the node to fault.
+	Name      string      `json:"name,omitempty"`
+	// Groups of ports separated by the partition.
+	Groups    [][]int     `json:"groups,omitempty"`
+	OneWay    bool        `json:"oneWay,omitempty"`
+	// Whether messages held by the partition are delivered on heal.
+	Release   bool        `json:"release,omitempty"`
+	// Number of messages awaiting decision per channel.
+	Depths    map[string]int `json:"depths,omitempty"`
+	// Criteria of the history request and records found.
+	Query     *store.Query   `json:"query,omitempty"`
+	Records   []store.Record `json:"records,omitempty"`
 }
 
 type wsMessage struct {
+	Channel string `json:"channel,omitempty"`
 	Src string `json:"src"`
 	Dst string `json:"dst"`
 	MsgNumber string `json:"msgNumber"`
 	Payload string `json:"payload"`
+	Tampered bool `json:"tampered,omitempty"`
+	Injected bool `json:"injected,omitempty"`
+	// Number of the original message if this one is its copy; copies are accepted already.
+	Origin string `json:"origin,omitempty"`
 }
 
 type Chans_ports struct {
-	MsgsDb MsgDb
+	Store *store.Store
 	MsgChan chan network.Message
+	Channels []network.Channel
+	Partitions *network.Partitions
+	// Applies faults to nodes; nil unless nodes are supervised.
+	Nemesis *nemesis.Nemesis
 }
 
 type CallCtx interface {
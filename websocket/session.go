@@ -2,15 +2,18 @@ package websocket
 
 import (
 	"context"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 	"time"
 	"hse-dss-efimov/network"
+	"hse-dss-efimov/store"
 	"strconv"
+	"strings"
 )
 
 const (
-	maxMessageSize = 512
+	maxMessageSize = 64 * 1024 // fits modified payloads
 	pingPeriod     = 5 * time.Second
 	readTimeout    = 15 * time.Second // must be greater than ping period
 	writeTimeout   = 5 * time.Second
@@ -21,31 +24,115 @@ type session struct {
 	logger zap.Logger
 
 	conn  *websocket.Conn
-	queue chan Message
-	db MsgDb
+	// JSON messages broadcast by the dispatcher.
+	queue chan interface{}
 }
 
-type MsgDb map[uint64]network.Message
+func wsMessageFor(msg network.MessageI) wsMessage {
+	wsMsg := wsMessage{Channel: msg.GetChannel(), Src: msg.GetSrc(), Dst: msg.GetDst(),
+		MsgNumber: strconv.FormatUint(msg.GetSeqNum(), 10), Payload: string(msg.GetPayload()),
+		Tampered: msg.IsTampered(), Injected: msg.IsInjected()}
+	if origin := msg.GetOrigin(); origin != 0 {
+		wsMsg.Origin = strconv.FormatUint(origin, 10)
+	}
+	return wsMsg
+}
 
 /*
  * Send message to WebSocket
  */
-func sendToWs(msg network.Message, update bool, s *session, messagesDb *MsgDb) {
-	msgNum := msg.Seqnum
-	wsMsg := wsMessage{Src: msg.Src, Dst: msg.Dst,
-		MsgNumber: strconv.FormatUint(msgNum, 10), Payload: string(msg.Payload)}
-	s.logger.Debug("sending json message to WS", zap.Any("msg", wsMsg))
-	if update {
-		(*messagesDb)[msgNum] = msg
+func sendToWs(msg interface{}, s *session) bool {
+	s.logger.Debug("sending json message to WS", zap.Any("msg", msg))
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		s.logger.Error("failed to send json message", zap.Error(err))
+		return false
 	}
+	return true
+}
 
-	if err := s.conn.WriteJSON(wsMsg); err != nil {
-		s.logger.Error("failed to send json message", zap.Error(err))
-		return
+// Parses comma-separated list of message numbers.
+func parseSeqNums(data string) ([]uint64, error) {
+	fields := strings.Split(data, ",")
+	seqnums := make([]uint64, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		seqnum, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		seqnums = append(seqnums, seqnum)
+	}
+	return seqnums, nil
+}
+
+func findChannel(channels []network.Channel, name string) (network.Channel, error) {
+	for _, channel := range channels {
+		if channel.GetName() == name {
+			return channel, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown channel %q", name)
+}
+
+func injectMessage(channels []network.Channel, name string, payload []byte) (uint64, error) {
+	channel, err := findChannel(channels, name)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := channel.Inject(payload)
+	if err != nil {
+		return 0, err
 	}
+	return msg.GetSeqNum(), nil
 }
 
-func (s *session) runLoop(ctx context.Context, callHandler CallHandler, msgDbChan *Chans_ports) {
+// Triggers connection-level fault of the given kind on the named channel.
+func triggerFault(channels []network.Channel, name string, kind string, d time.Duration) error {
+	channel, err := findChannel(channels, name)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "reset":
+		channel.ResetConnections()
+	case "halfclose-inbound":
+		channel.HalfCloseConnections(true)
+	case "halfclose-outbound":
+		channel.HalfCloseConnections(false)
+	case "refuse":
+		channel.RefuseConnections(d)
+	case "drop-frame":
+		if channel.DropPartialFrame() == 0 {
+			return fmt.Errorf("no frame is partially written to destination")
+		}
+	default:
+		return fmt.Errorf("unknown fault %q", kind)
+	}
+	return nil
+}
+
+// Replies to request; returns false if websocket is broken.
+func (s *session) reply(msg Message) bool {
+	return sendToWs(msg, s)
+}
+
+// Looks pending Message up for a decision by the session. Modification leaves
+// Message pending, so it does not claim Message.
+func (s *session) lookup(st *store.Store, msgNumber uint64, modify bool) (network.MessageI, bool) {
+	if !modify {
+		return st.Claim(msgNumber, s.id)
+	}
+	record, ok := st.Get(msgNumber)
+	if !ok || record.Status != store.StatusPending || record.DecidedBy != "" {
+		return nil, false
+	}
+	return record.Message(), true
+}
+
+func (s *session) runLoop(ctx context.Context, dispatcher *Dispatcher, msgDbChan *Chans_ports) {
 	defer func() {
 		s.conn.Close()
 		s.logger.Debug("websocket was closed")
@@ -67,24 +154,30 @@ func (s *session) runLoop(ctx context.Context, callHandler CallHandler, msgDbCha
 		err error
 	}
 	readCh := make(chan readResult)
+	// Replies to requests served in the background.
+	replyCh := make(chan Message)
 
-	msgsDb := s.db
-
+	// Connection supports a single reader, so next read starts once the previous one is served.
+	reading := false
 	for {
-		go func() {
-			var msg = &Message{}
-			err := s.conn.ReadJSON(msg)
-			select {
-			case <-ctx.Done():
-			case readCh <- readResult{*msg, err}:
-			}
-		}()
+		if !reading {
+			reading = true
+			go func() {
+				var msg = &Message{}
+				err := s.conn.ReadJSON(msg)
+				select {
+				case <-ctx.Done():
+				case readCh <- readResult{*msg, err}:
+				}
+			}()
+		}
 
 		select {
 		case <-ctx.Done():
 			return
 
 		case readResult := <-readCh:
+			reading = false
 			if msg, err := readResult.msg, readResult.err; err != nil {
 				if closeErr, ok := err.(*websocket.CloseError); ok {
 					s.logger.Debug("received close message", zap.Error(closeErr))
@@ -100,8 +193,110 @@ func (s *session) runLoop(ctx context.Context, callHandler CallHandler, msgDbCha
 					req := msg.Request
 					switch req {
 					case "db":
-						for key := range msgsDb {
-							sendToWs(msgsDb[key], false, s, &msgsDb)
+						for _, channel := range msgDbChan.Channels {
+							for _, msgNet := range channel.Pending() {
+								if !sendToWs(wsMessageFor(msgNet), s) {
+									return
+								}
+							}
+						}
+					case "history":
+						query := store.Query{}
+						if msg.Query != nil {
+							query = *msg.Query
+						}
+						reply := Message{Kind: MK_Response, Request: req, Records: msgDbChan.Store.Query(query)}
+						if !s.reply(reply) {
+							return
+						}
+					case "release":
+						reply := Message{Kind: MK_Response, Request: req}
+						if order, err := parseSeqNums(msg.Data); err != nil {
+							s.logger.Debug("Fail to parse release order", zap.Any("err", err))
+							reply.Data = fmt.Sprintf("bad release order: %v", err)
+						} else {
+							released := 0
+							for _, channel := range msgDbChan.Channels {
+								released += channel.Release(order)
+							}
+							s.logger.Debug("released held messages", zap.Uint64s("order", order), zap.Int("released", released))
+							reply.MsgNumber = strconv.Itoa(released)
+							if released < len(order) {
+								reply.Data = fmt.Sprintf("released %d of %d messages, others are not held", released, len(order))
+							}
+						}
+						if !s.reply(reply) {
+							return
+						}
+					case "inject":
+						reply := Message{Kind: MK_Response, Request: req}
+						if seqnum, err := injectMessage(msgDbChan.Channels, msg.Channel, []byte(msg.Payload)); err != nil {
+							s.logger.Debug("Fail to inject message", zap.Error(err))
+							reply.Data = err.Error()
+						} else {
+							reply.MsgNumber = strconv.FormatUint(seqnum, 10)
+						}
+						if !s.reply(reply) {
+							return
+						}
+					case "partition":
+						reply := Message{Kind: MK_Response, Request: req}
+						if err := msgDbChan.Partitions.Partition(msg.Name, msg.Groups, msg.OneWay); err != nil {
+							s.logger.Debug("Fail to partition network", zap.Error(err))
+							reply.Data = err.Error()
+						}
+						if !s.reply(reply) {
+							return
+						}
+					case "fault":
+						reply := Message{Kind: MK_Response, Request: req}
+						d := time.Duration(msg.Delay) * time.Millisecond
+						if err := triggerFault(msgDbChan.Channels, msg.Channel, msg.Data, d); err != nil {
+							s.logger.Debug("Fail to trigger fault", zap.Error(err))
+							reply.Data = err.Error()
+						}
+						if !s.reply(reply) {
+							return
+						}
+					case "nemesis":
+						reply := Message{Kind: MK_Response, Request: req}
+						if msgDbChan.Nemesis == nil {
+							reply.Data = "nodes are not supervised"
+							if !s.reply(reply) {
+								return
+							}
+							continue
+						}
+						// Stopping a node may take as long as its stop timeout, so the
+						// session keeps serving meanwhile.
+						go func(fault string, node string, d time.Duration) {
+							if err := msgDbChan.Nemesis.Apply(fault, node, d); err != nil {
+								s.logger.Debug("Fail to apply fault to node", zap.Error(err))
+								reply.Data = err.Error()
+							}
+							select {
+							case <-ctx.Done():
+							case replyCh <- reply:
+							}
+						}(msg.Data, msg.Name, time.Duration(msg.Delay)*time.Millisecond)
+					case "pending":
+						reply := Message{Kind: MK_Response, Request: req, Depths: make(map[string]int)}
+						for _, channel := range msgDbChan.Channels {
+							reply.Depths[channel.GetName()] = len(channel.Pending())
+						}
+						if !s.reply(reply) {
+							return
+						}
+					case "heal":
+						reply := Message{Kind: MK_Response, Request: req}
+						if held, err := msgDbChan.Partitions.Heal(msg.Name, msg.Release); err != nil {
+							s.logger.Debug("Fail to heal network partition", zap.Error(err))
+							reply.Data = err.Error()
+						} else {
+							reply.MsgNumber = strconv.Itoa(held)
+						}
+						if !s.reply(reply) {
+							return
 						}
 					default:
 					}
@@ -111,27 +306,71 @@ func (s *session) runLoop(ctx context.Context, callHandler CallHandler, msgDbCha
 						s.logger.Debug("Fail to cast message number", zap.Any("err", err))
 						continue
 					}
-					msgNet, ok := msgsDb[msgNumber]
+					var deadline time.Time
+					if msg.Data == "3" && msg.At != "" {
+						if deadline, err = time.Parse(time.RFC3339Nano, msg.At); err != nil {
+							s.logger.Debug("Fail to parse acceptance deadline", zap.Any("err", err))
+							continue
+						}
+					}
+					// Modified Message stays pending until decided on.
+					msgNet, ok := s.lookup(msgDbChan.Store, msgNumber, msg.Data == "4")
 					if !ok {
 						s.logger.Debug("There is no message with such message number or decision happened")
 						continue
 					}
-					decision := msg.Data
-					msgNet.DecideFn(decision == "1")
-					delete(msgsDb, msgNumber)
+					switch decision := msg.Data; decision {
+					case "1":
+						msgNet.Accept()
+					case "2":
+						if msg.Copies < 1 {
+							msg.Copies = 1
+						} else if msg.Copies > network.MaxDuplicates {
+							s.logger.Debug("Too many copies requested", zap.Int("copies", msg.Copies))
+							msg.Copies = network.MaxDuplicates
+						}
+						// Copies reach the views as events of their own; see Dispatcher.CopyObserver.
+						msgNet.Duplicate(msg.Copies)
+					case "3":
+						if msg.At != "" {
+							msgNet.AcceptAt(deadline)
+						} else {
+							msgNet.AcceptAfter(time.Duration(msg.Delay) * time.Millisecond)
+						}
+					case "4":
+						if err := msgNet.Modify([]byte(msg.Payload)); err != nil {
+							s.logger.Debug("Fail to modify message", zap.Error(err))
+							reply := Message{Kind: MK_Response, Request: "modify", MsgNumber: msg.MsgNumber, Data: err.Error()}
+							if !s.reply(reply) {
+								return
+							}
+							// Other views keep the payload they show.
+							continue
+						}
+					default:
+						msgNet.Reject()
+					}
+					// Other views learn about the decision.
+					dispatcher.Broadcast(Message{Kind: MK_Broadcast, MsgNumber: msg.MsgNumber, Data: msg.Data,
+						Copies: msg.Copies, Delay: msg.Delay, At: msg.At, Payload: msg.Payload})
 				default:
 					s.logger.Debug("ignoring message", zap.Any("msg", msg))
 				}
 			}
 
-		case msg, ok := <-msgDbChan.MsgChan:
-			s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		case msg, ok := <-s.queue:
 			if !ok {
 				s.logger.Debug("sending close message")
+				s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 				s.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
-			} else {
-				sendToWs(msg,true, s, &msgsDb)
+			} else if !sendToWs(msg, s) {
+				return
+			}
+
+		case reply := <-replyCh:
+			if !s.reply(reply) {
+				return
 			}
 
 		case <-ticker.C:
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"hse-dss-efimov/nemesis"
+	"hse-dss-efimov/network"
+	"hse-dss-efimov/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serves web sessions over msgDbChan; returns connected client.
+func dialSession(t *testing.T, msgDbChan *Chans_ports) *websocket.Conn {
+	d := NewDispatcher(*zap.NewNop(), nil)
+	t.Cleanup(d.Close)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HttpHandler(d, w, r, msgDbChan)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var hello Message
+	if err := conn.ReadJSON(&hello); err != nil || hello.Kind != MK_Hello {
+		t.Fatalf("expected hello, got %+v, %v", hello, err)
+	}
+	return conn
+}
+
+func TestSessionReportsFailedModification(t *testing.T) {
+	msg := &network.Message{Seqnum: 1, Payload: []byte("x")}
+	msgDbChan := &Chans_ports{Store: store.NewStore()}
+	msgDbChan.Store.Received(msg)
+	conn := dialSession(t, msgDbChan)
+
+	oversized := strings.Repeat("y", 33*1024)
+	if err := conn.WriteJSON(Message{Kind: MK_Response, MsgNumber: "1", Data: "4", Payload: oversized}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply Message
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Kind != MK_Response || reply.Request != "modify" || reply.MsgNumber != "1" || reply.Data == "" {
+		t.Errorf("expected error reply, got %+v", reply)
+	}
+	if string(msg.Payload) != "x" || msg.Tampered {
+		t.Errorf("Message must not be modified")
+	}
+}
+
+// Nodes taking as long to stop as the test says.
+type stallingNodes struct {
+	stopped chan struct{}
+}
+
+func (n *stallingNodes) Kill(name string) error    { return nil }
+func (n *stallingNodes) Stop(name string) error    { <-n.stopped; return nil }
+func (n *stallingNodes) Restart(name string) error { return nil }
+func (n *stallingNodes) Pause(name string) error   { return nil }
+func (n *stallingNodes) Resume(name string) error  { return nil }
+
+func TestSessionServesWhileFaultIsApplied(t *testing.T) {
+	nodes := &stallingNodes{stopped: make(chan struct{})}
+	msgDbChan := &Chans_ports{Store: store.NewStore(),
+		Nemesis: nemesis.NewNemesis(nodes, map[string][]int{"a": nil}, nil, nemesis.PendingDrop, *zap.NewNop())}
+	conn := dialSession(t, msgDbChan)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.WriteJSON(Message{Kind: MK_Request, Request: "nemesis", Data: nemesis.FaultStop, Name: "a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.WriteJSON(Message{Kind: MK_Request, Request: "pending"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var reply Message
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Request != "pending" {
+		t.Fatalf("expected session to serve while node stops, got %+v", reply)
+	}
+
+	close(nodes.stopped)
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.Request != "nemesis" || reply.Data != "" {
+		t.Errorf("expected fault to be applied, got %+v", reply)
+	}
+}
+
+func TestSessionRepliesToRelease(t *testing.T) {
+	conn := dialSession(t, &Chans_ports{Store: store.NewStore()})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, order := range []string{"1,x", "1,2"} {
+		if err := conn.WriteJSON(Message{Kind: MK_Request, Request: "release", Data: order}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var reply Message
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Nothing is held, so nothing is released.
+		if reply.Kind != MK_Response || reply.Request != "release" || reply.Data == "" {
+			t.Errorf("expected error reply for order %q, got %+v", order, reply)
+		}
+	}
+}